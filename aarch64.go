@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aarch64 emits A64 code following the AAPCS64: arguments go in x0-x7,
+// frames are linked through x29 with stp/ldp and sp stays 16-byte aligned,
+// so every push takes 16 bytes. x0 is the accumulator, x1 the operand
+// register, and x9 holds the code address of a closure being called. x16
+// holds offsets too large for an instruction.
+type aarch64 struct{}
+
+func (aarch64) wordSize() int          { return 8 }
+func (aarch64) argumentRegisters() int { return 8 }
+func (aarch64) stackAlignment() int    { return 16 }
+
+func (aarch64) name(r register) string {
+	switch r {
+	case accumulator:
+		return "x0"
+	case operand:
+		return "x1"
+	default:
+		return "x29"
+	}
+}
+
+func (a aarch64) emitPrologue(parameters, frameSize int) {
+	emit("  stp x29, x30, [sp, #-16]!")
+	emit("  mov x29, sp")
+	if frameSize > 0 {
+		a.emitReserve(frameSize)
+	}
+	for i := 0; i < parameters; i++ {
+		emit(fmt.Sprintf("  str x%d, [x29, #%d]", i, -8*(i+1)))
+	}
+}
+
+func (aarch64) emitReturn() {
+	emit("  mov sp, x29")
+	emit("  ldp x29, x30, [sp], #16")
+	emit("  ret")
+}
+
+func (aarch64) emitFunctionEnd() {
+	// keep the literals of ldr x0, =... within reach of the function
+	emit("  .ltorg")
+}
+
+func (aarch64) emitSetStackBase() {
+	emit("  ldr x1, =__baseline_stack_base")
+	emit("  str x29, [x1]")
+}
+
+func (a aarch64) emitNumber(to register, value int) {
+	if value >= 0 && value <= 0xffff {
+		emit(fmt.Sprintf("  mov %s, #%d", a.name(to), value))
+	} else {
+		emit(fmt.Sprintf("  ldr %s, =%d", a.name(to), value))
+	}
+}
+
+func (a aarch64) emitAddress(to register, label string) {
+	emit(fmt.Sprintf("  ldr %s, =%s", a.name(to), label))
+}
+
+func (a aarch64) emitMove(to, from register) {
+	emit(fmt.Sprintf("  mov %s, %s", a.name(to), a.name(from)))
+}
+
+// memory emits a load or store of register at offset from base. Offsets
+// that fit neither the scaled nor the unscaled immediate go through x16.
+func (aarch64) memory(instruction, register, base string, offset int) {
+	if offset >= -256 && offset <= 255 || offset >= 0 && offset%8 == 0 && offset <= 32760 {
+		emit(fmt.Sprintf("  %s %s, [%s, #%d]", instruction, register, base, offset))
+		return
+	}
+	emit(fmt.Sprintf("  ldr x16, =%d", offset))
+	emit(fmt.Sprintf("  %s %s, [%s, x16]", instruction, register, base))
+}
+
+func (a aarch64) emitLoad(to, base register, offset int) {
+	a.memory("ldr", a.name(to), a.name(base), offset)
+}
+
+func (a aarch64) emitStore(from, base register, offset int) {
+	a.memory("str", a.name(from), a.name(base), offset)
+}
+
+func (aarch64) emitPush() {
+	emit("  str x0, [sp, #-16]!")
+}
+
+func (aarch64) emitPop() {
+	emit("  ldr x1, [sp], #16")
+}
+
+func (aarch64) emitPeek() {
+	emit("  ldr x1, [sp]")
+}
+
+// adjust moves sp by size bytes with add or sub.
+func (aarch64) adjust(instruction string, size int) {
+	if size <= 4095 {
+		emit(fmt.Sprintf("  %s sp, sp, #%d", instruction, size))
+		return
+	}
+	emit(fmt.Sprintf("  ldr x16, =%d", size))
+	emit(fmt.Sprintf("  %s sp, sp, x16", instruction))
+}
+
+func (a aarch64) emitReserve(size int) {
+	a.adjust("sub", size)
+}
+
+func (a aarch64) emitRelease(size int) {
+	a.adjust("add", size)
+}
+
+func (a aarch64) emitStoreArgument(offset int) {
+	a.memory("str", "x0", "sp", offset)
+}
+
+func (aarch64) emitLoadArguments(count int) {
+	for i := 0; i+1 < count; i += 2 {
+		emit(fmt.Sprintf("  ldp x%d, x%d, [sp, #%d]", i, i+1, 8*i))
+	}
+	if count%2 == 1 {
+		emit(fmt.Sprintf("  ldr x%d, [sp, #%d]", count-1, 8*(count-1)))
+	}
+}
+
+func (aarch64) emitCall(function string) {
+	emit(fmt.Sprintf("  bl %s", function))
+}
+
+func (a aarch64) emitPrepareClosureCall(environmentOffset int) {
+	emit("  ldr x9, [x0]")
+	emit("  ldr x1, [x0, #8]")
+	a.memory("str", "x1", "sp", environmentOffset)
+}
+
+func (aarch64) emitCallClosure() {
+	emit("  blr x9")
+}
+
+func (aarch64) emitNot() {
+	emit("  cmp x0, #0")
+	emit("  cset x0, eq")
+}
+
+func (aarch64) emitNegate() {
+	emit("  neg w0, w0")
+	emit("  sxtw x0, w0")
+}
+
+// emitBinary computes in the w registers and sign-extends the result.
+// sdiv gives a quotient of 0 when dividing by zero, so the remainder is
+// the dividend, as on ARM.
+func (aarch64) emitBinary(op operator) {
+	switch op {
+	case addition:
+		emit("  add w0, w1, w0")
+	case subtraction:
+		emit("  sub w0, w1, w0")
+	case multiplication:
+		emit("  mul w0, w1, w0")
+	case division:
+		emit("  sdiv w0, w1, w0")
+	case remainder:
+		emit("  sdiv w2, w1, w0")
+		emit("  msub w0, w2, w0, w1")
+	case equality:
+		emit("  cmp x1, x0")
+		emit("  cset x0, eq")
+		return
+	case inequality:
+		emit("  cmp x1, x0")
+		emit("  cset x0, ne")
+		return
+	}
+	emit("  sxtw x0, w0")
+}
+
+func (aarch64) emitBranchIfZero(target *Label) {
+	emit(fmt.Sprintf("  cbz x0, %s", target))
+}
+
+func (a aarch64) emitBranchIfEqual(value int, target *Label) {
+	a.emitNumber(operand, value)
+	emit("  cmp x0, x1")
+	emit(fmt.Sprintf("  b.eq %s", target))
+}
+
+func (aarch64) emitJump(target *Label) {
+	emit(fmt.Sprintf("  b %s", target))
+}
+
+// emitJumpTable rebases x0 to the lowest case value; the unsigned
+// comparison also sends values below it to otherwise.
+func (a aarch64) emitJumpTable(low int, table []*Label, otherwise *Label) {
+	addresses := NewLabel()
+	a.emitNumber(operand, low)
+	emit("  sub x0, x0, x1")
+	a.emitNumber(operand, len(table)-1)
+	emit("  cmp x0, x1")
+	emit(fmt.Sprintf("  b.hi %s", otherwise))
+	emit(fmt.Sprintf("  adr x1, %s", addresses))
+	emit("  ldr x1, [x1, x0, lsl #3]")
+	emit("  br x1")
+	emit("  .balign 8")
+	emit(fmt.Sprintf("%s:", addresses))
+	labels := []string{}
+	for _, label := range table {
+		labels = append(labels, label.String())
+	}
+	emit(fmt.Sprintf("  .quad %s", strings.Join(labels, ", ")))
+}
+
+// runtime is the ARM runtime with 8-byte words: block headers hold the
+// size and the next free block in 16 bytes, and the bitmap has one bit per
+// 16 bytes. Shapes keep their 4-byte ids.
+func (aarch64) runtime() string {
+	return aarch64Runtime
+}
+
+const aarch64Runtime = `
+.set BASELINE_HEAP_SIZE, %d
+
+.bss
+.balign 16
+__baseline_heap:
+  .space BASELINE_HEAP_SIZE
+__baseline_starts:
+  .space BASELINE_HEAP_SIZE / 128
+.data
+.balign 8
+__baseline_free:
+  .quad 0
+__baseline_heap_ready:
+  .quad 0
+__baseline_stack_base:
+  .quad 0
+.text
+
+// __baseline_alloc returns x0 bytes of zeroed memory, collecting garbage
+// when the free list has no block large enough. The runtime leaves x29
+// alone, so the collector sees its frames as part of the caller's.
+.global __baseline_alloc
+__baseline_alloc:
+  stp x19, x30, [sp, #-16]!
+  add x19, x0, #31
+  and x19, x19, #-16
+  ldr x1, =__baseline_heap_ready
+  ldr x2, [x1]
+  cbnz x2, .Lalloc_find
+  mov x2, #1
+  str x2, [x1]
+  ldr x0, =__baseline_heap
+  ldr x2, =BASELINE_HEAP_SIZE
+  str x2, [x0]
+  str xzr, [x0, #8]
+  ldr x1, =__baseline_free
+  str x0, [x1]
+.Lalloc_find:
+  bl .Lfind
+  cbnz x0, .Lalloc_found
+  bl __baseline_collect
+  bl .Lfind
+  cbnz x0, .Lalloc_found
+  bl abort
+.Lalloc_found:
+  mov x4, x0
+  bl .Lstart_bit
+  ldrb w3, [x1]
+  orr w3, w3, w2
+  strb w3, [x1]
+  ldr x2, [x4]
+  add x2, x4, x2
+  add x1, x4, #16
+.Lalloc_zero:
+  cmp x1, x2
+  b.hs .Lalloc_done
+  str xzr, [x1], #8
+  b .Lalloc_zero
+.Lalloc_done:
+  add x0, x4, #16
+  ldp x19, x30, [sp], #16
+  ret
+
+// __baseline_field returns the address of the field with id x1 of the
+// record at x0, aborting when the record's shape has no such field.
+.global __baseline_field
+__baseline_field:
+  ldr x2, [x0], #8
+  ldr w3, [x2], #4
+.Lfield_loop:
+  cbz w3, .Lfield_missing
+  sub w3, w3, #1
+  ldr w4, [x2], #4
+  cmp w4, w1
+  b.eq .Lfield_found
+  add x0, x0, #8
+  b .Lfield_loop
+.Lfield_found:
+  ret
+.Lfield_missing:
+  bl abort
+
+// .Lfind takes the first free block of at least x19 bytes off the free
+// list and returns it in x0, or 0 when there is none. The rest of a larger
+// block stays on the list.
+.Lfind:
+  ldr x1, =__baseline_free
+.Lfind_loop:
+  ldr x0, [x1]
+  cbz x0, .Lfind_done
+  ldr x2, [x0]
+  cmp x2, x19
+  b.hs .Lfind_fits
+  add x1, x0, #8
+  b .Lfind_loop
+.Lfind_fits:
+  sub x3, x2, x19
+  cmp x3, #32
+  b.hs .Lfind_split
+  ldr x5, [x0, #8]
+  str x5, [x1]
+  ret
+.Lfind_split:
+  add x5, x0, x19
+  str x3, [x5]
+  ldr x6, [x0, #8]
+  str x6, [x5, #8]
+  str x5, [x1]
+  str x19, [x0]
+.Lfind_done:
+  ret
+
+// .Lstart_bit returns the bitmap byte for the block at x0 in x1 and the
+// bit within it in x2.
+.Lstart_bit:
+  ldr x1, =__baseline_heap
+  sub x3, x0, x1
+  lsr x3, x3, #4
+  and x2, x3, #7
+  mov x1, #1
+  lsl x2, x1, x2
+  ldr x1, =__baseline_starts
+  add x1, x1, x3, lsr #3
+  ret
+
+.global __baseline_collect
+__baseline_collect:
+  stp x19, x30, [sp, #-96]!
+  stp x20, x21, [sp, #16]
+  stp x22, x23, [sp, #32]
+  stp x24, x25, [sp, #48]
+  stp x26, x27, [sp, #64]
+  str x28, [sp, #80]
+  ldr x0, =__baseline_data_start
+  ldr x1, =__baseline_data_end
+  bl .Lmark_range
+  ldr x0, =__baseline_bss_start
+  ldr x1, =__baseline_bss_end
+  bl .Lmark_range
+  ldr x21, =__baseline_stack_base
+  ldr x21, [x21]
+  mov x22, sp
+  mov x23, x29
+.Lcollect_frames:
+  cbz x23, .Lcollect_sweep
+  mov x0, x22
+  mov x1, x23
+  bl .Lmark_range
+  cmp x23, x21
+  b.eq .Lcollect_sweep
+  add x22, x23, #16
+  ldr x23, [x23]
+  b .Lcollect_frames
+.Lcollect_sweep:
+  bl .Lsweep
+  ldr x28, [sp, #80]
+  ldp x26, x27, [sp, #64]
+  ldp x24, x25, [sp, #48]
+  ldp x22, x23, [sp, #32]
+  ldp x20, x21, [sp, #16]
+  ldp x19, x30, [sp], #96
+  ret
+
+// .Lmark_range marks every block referenced by a word in [x0, x1).
+.Lmark_range:
+  stp x19, x30, [sp, #-32]!
+  str x20, [sp, #16]
+  mov x19, x0
+  mov x20, x1
+.Lmark_range_loop:
+  cmp x19, x20
+  b.hs .Lmark_range_done
+  ldr x0, [x19], #8
+  bl .Lmark
+  b .Lmark_range_loop
+.Lmark_range_done:
+  ldr x20, [sp, #16]
+  ldp x19, x30, [sp], #32
+  ret
+
+// .Lmark marks the block whose payload x0 points to, if any, and then
+// everything reachable from it.
+.Lmark:
+  ldr x1, =__baseline_heap
+  sub x2, x0, x1
+  cmp x2, #16
+  b.lo .Lmark_skip
+  ldr x3, =BASELINE_HEAP_SIZE
+  cmp x2, x3
+  b.hs .Lmark_skip
+  tst x2, #15
+  b.ne .Lmark_skip
+  stp x19, x30, [sp, #-16]!
+  sub x19, x0, #16
+  mov x0, x19
+  bl .Lstart_bit
+  ldrb w3, [x1]
+  tst w3, w2
+  b.eq .Lmark_done
+  ldr x1, [x19]
+  tbnz x1, #0, .Lmark_done
+  orr x2, x1, #1
+  str x2, [x19]
+  add x0, x19, #16
+  add x1, x19, x1
+  bl .Lmark_range
+.Lmark_done:
+  ldp x19, x30, [sp], #16
+.Lmark_skip:
+  ret
+
+// .Lsweep frees unmarked blocks, clears the marks of the others and
+// rebuilds the free list in address order, merging neighbouring blocks.
+.Lsweep:
+  stp x19, x30, [sp, #-64]!
+  stp x20, x21, [sp, #16]
+  stp x22, x23, [sp, #32]
+  str x24, [sp, #48]
+  ldr x19, =__baseline_heap
+  ldr x20, =BASELINE_HEAP_SIZE
+  add x20, x19, x20
+  ldr x23, =__baseline_free
+  mov x22, #0
+.Lsweep_loop:
+  cmp x19, x20
+  b.hs .Lsweep_done
+  ldr x21, [x19]
+  and x24, x21, #-2
+  mov x0, x19
+  bl .Lstart_bit
+  ldrb w3, [x1]
+  tst w3, w2
+  b.eq .Lsweep_free
+  tbnz x21, #0, .Lsweep_live
+  bic w3, w3, w2
+  strb w3, [x1]
+  b .Lsweep_free
+.Lsweep_live:
+  str x24, [x19]
+  mov x22, #0
+  add x19, x19, x24
+  b .Lsweep_loop
+.Lsweep_free:
+  cbz x22, .Lsweep_new_free
+  ldr x0, [x22]
+  add x0, x0, x24
+  str x0, [x22]
+  add x19, x19, x24
+  b .Lsweep_loop
+.Lsweep_new_free:
+  str x24, [x19]
+  str x19, [x23]
+  add x23, x19, #8
+  mov x22, x19
+  add x19, x19, x24
+  b .Lsweep_loop
+.Lsweep_done:
+  str xzr, [x23]
+  ldr x24, [sp, #48]
+  ldp x22, x23, [sp, #32]
+  ldp x20, x21, [sp, #16]
+  ldp x19, x30, [sp], #64
+  ret
+  .ltorg`
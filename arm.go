@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// hardwareDivide selects sdiv; cores without a divider call the EABI
+// helper from libgcc instead.
+var hardwareDivide = true
+
+// arm emits ARMv7-A code following the AAPCS: arguments go in r0-r3, frames
+// are linked through fp with push {fp, lr} and sp stays 8-byte aligned, so
+// every push takes 8 bytes. r0 is the accumulator, r1 the operand
+// register, and ip holds the code address of a closure being called as
+// well as offsets too large for an instruction.
+type arm struct{}
+
+func (arm) wordSize() int          { return 4 }
+func (arm) argumentRegisters() int { return 4 }
+func (arm) stackAlignment() int    { return 8 }
+
+func (arm) name(r register) string {
+	switch r {
+	case accumulator:
+		return "r0"
+	case operand:
+		return "r1"
+	default:
+		return "fp"
+	}
+}
+
+// armImmediate reports whether n fits the operand of a data processing
+// instruction: 8 bits rotated right by an even amount.
+func armImmediate(n int) bool {
+	for rotation := 0; rotation < 32; rotation += 2 {
+		if bits.RotateLeft32(uint32(n), rotation) < 256 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a arm) emitPrologue(parameters, frameSize int) {
+	emit("  push {fp, lr}")
+	emit("  mov fp, sp")
+	if frameSize > 0 {
+		a.emitReserve(frameSize)
+	}
+	for i := 0; i < parameters; i++ {
+		emit(fmt.Sprintf("  str r%d, [fp, #%d]", i, -4*(i+1)))
+	}
+}
+
+func (arm) emitReturn() {
+	emit("  mov sp, fp")
+	emit("  pop {fp, pc}")
+}
+
+func (arm) emitFunctionEnd() {
+	// keep the literals of ldr r0, =... within reach of the function
+	emit("  .ltorg")
+}
+
+func (arm) emitSetStackBase() {
+	emit("  ldr r1, =__baseline_stack_base")
+	emit("  str fp, [r1]")
+}
+
+func (a arm) emitNumber(to register, value int) {
+	if armImmediate(value) {
+		emit(fmt.Sprintf("  mov %s, #%d", a.name(to), value))
+	} else {
+		emit(fmt.Sprintf("  ldr %s, =%d", a.name(to), value))
+	}
+}
+
+func (a arm) emitAddress(to register, label string) {
+	emit(fmt.Sprintf("  ldr %s, =%s", a.name(to), label))
+}
+
+func (a arm) emitMove(to, from register) {
+	emit(fmt.Sprintf("  mov %s, %s", a.name(to), a.name(from)))
+}
+
+// memory emits a load or store of register at offset from base. Offsets
+// out of reach of the 12-bit immediate go through ip.
+func (arm) memory(instruction, register, base string, offset int) {
+	if offset >= -4095 && offset <= 4095 {
+		emit(fmt.Sprintf("  %s %s, [%s, #%d]", instruction, register, base, offset))
+		return
+	}
+	emit(fmt.Sprintf("  ldr ip, =%d", offset))
+	emit(fmt.Sprintf("  %s %s, [%s, ip]", instruction, register, base))
+}
+
+func (a arm) emitLoad(to, base register, offset int) {
+	a.memory("ldr", a.name(to), a.name(base), offset)
+}
+
+func (a arm) emitStore(from, base register, offset int) {
+	a.memory("str", a.name(from), a.name(base), offset)
+}
+
+// emitPush pushes ip along with r0 to keep sp 8-byte aligned.
+func (arm) emitPush() {
+	emit("  push {r0, ip}")
+}
+
+func (arm) emitPop() {
+	emit("  pop {r1, ip}")
+}
+
+func (arm) emitPeek() {
+	emit("  ldr r1, [sp]")
+}
+
+// adjust moves sp by size bytes with add or sub.
+func (arm) adjust(instruction string, size int) {
+	if armImmediate(size) {
+		emit(fmt.Sprintf("  %s sp, sp, #%d", instruction, size))
+		return
+	}
+	emit(fmt.Sprintf("  ldr ip, =%d", size))
+	emit(fmt.Sprintf("  %s sp, sp, ip", instruction))
+}
+
+func (a arm) emitReserve(size int) {
+	a.adjust("sub", size)
+}
+
+func (a arm) emitRelease(size int) {
+	a.adjust("add", size)
+}
+
+func (a arm) emitStoreArgument(offset int) {
+	a.memory("str", "r0", "sp", offset)
+}
+
+func (arm) emitLoadArguments(count int) {
+	for i := 0; i < count; i++ {
+		emit(fmt.Sprintf("  ldr r%d, [sp, #%d]", i, 4*i))
+	}
+}
+
+func (arm) emitCall(function string) {
+	emit(fmt.Sprintf("  bl %s", function))
+}
+
+func (a arm) emitPrepareClosureCall(environmentOffset int) {
+	emit("  ldr ip, [r0]")
+	emit("  ldr r1, [r0, #4]")
+	a.memory("str", "r1", "sp", environmentOffset)
+}
+
+func (arm) emitCallClosure() {
+	emit("  blx ip")
+}
+
+func (arm) emitNot() {
+	emit("  cmp r0, #0")
+	emit("  moveq r0, #1")
+	emit("  movne r0, #0")
+}
+
+func (arm) emitNegate() {
+	emit("  rsb r0, r0, #0")
+}
+
+func (arm) emitBinary(op operator) {
+	switch op {
+	case addition:
+		emit("  add r0, r1, r0")
+	case subtraction:
+		emit("  sub r0, r1, r0")
+	case multiplication:
+		emit("  mul r0, r1, r0")
+	case division:
+		emitDivision()
+	case remainder:
+		emitDivision()
+		emit("  mov r0, r1")
+	case equality:
+		emit("  cmp r1, r0")
+		emit("  moveq r0, #1")
+		emit("  movne r0, #0")
+	case inequality:
+		emit("  cmp r1, r0")
+		emit("  movne r0, #1")
+		emit("  moveq r0, #0")
+	}
+}
+
+// emitDivision divides r1 by r0, leaving the quotient in r0 and the
+// remainder in r1. Division truncates toward zero, and dividing by zero
+// gives a quotient of 0 and returns the dividend as the remainder, which
+// is what sdiv does when the divide-by-zero trap is disabled.
+func emitDivision() {
+	if hardwareDivide {
+		emit("  sdiv r2, r1, r0")
+		emit("  mls r1, r2, r0, r1")
+		emit("  mov r0, r2")
+		return
+	}
+
+	divisionEnd := NewLabel()
+	emit("  cmp r0, #0")
+	emit(fmt.Sprintf("  beq %s", divisionEnd))
+	emit("  mov r2, r0")
+	emit("  mov r0, r1")
+	emit("  mov r1, r2")
+	emit("  bl __aeabi_idivmod")
+	emit(fmt.Sprintf("%s:", divisionEnd))
+}
+
+func (arm) emitBranchIfZero(target *Label) {
+	emit("  cmp r0, #0")
+	emit(fmt.Sprintf("  beq %s", target))
+}
+
+func (a arm) emitBranchIfEqual(value int, target *Label) {
+	if armImmediate(value) {
+		emit(fmt.Sprintf("  cmp r0, #%d", value))
+	} else {
+		a.emitNumber(operand, value)
+		emit("  cmp r0, r1")
+	}
+	emit(fmt.Sprintf("  beq %s", target))
+}
+
+func (arm) emitJump(target *Label) {
+	emit(fmt.Sprintf("  b %s", target))
+}
+
+// emitJumpTable rebases r0 to the lowest case value; the unsigned
+// comparison also sends values below it to otherwise.
+func (a arm) emitJumpTable(low int, table []*Label, otherwise *Label) {
+	a.emitNumber(operand, low)
+	emit("  sub r0, r0, r1")
+	a.emitNumber(operand, len(table)-1)
+	emit("  cmp r0, r1")
+	emit(fmt.Sprintf("  bhi %s", otherwise))
+	// pc reads as the address of the table, two instructions ahead
+	emit("  ldr pc, [pc, r0, lsl #2]")
+	emit("  nop")
+	labels := []string{}
+	for _, label := range table {
+		labels = append(labels, label.String())
+	}
+	emit(fmt.Sprintf("  .word %s", strings.Join(labels, ", ")))
+}
+
+func (arm) runtime() string {
+	return runtime
+}
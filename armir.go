@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// literalPoolDistance is the number of instructions after which the
+// emitter places a literal pool between two blocks, keeping the
+// literals of ldr r0, =... within reach of large functions.
+const literalPoolDistance = 500
+
+// emitARM emits the program for ARM with the runtime and data layout the
+// generator uses for the arm machine.
+func (p *irProgram) emitARM(env *Environment) {
+	parts := p.parts
+	for _, name := range parts.externs {
+		emit(fmt.Sprintf(".extern %s", name))
+	}
+
+	// the collector scans the globals between these labels for roots
+	emit(".data")
+	emit(".balign 4")
+	emit("__baseline_data_start:")
+	for _, global := range parts.initialized {
+		emit(fmt.Sprintf("%s:", globalSymbol(global.name)))
+		emit(fmt.Sprintf("  .word %d", global.value.(Number).value))
+	}
+	emit("__baseline_data_end:")
+	emit(".bss")
+	emit(".balign 4")
+	emit("__baseline_bss_start:")
+	for _, name := range parts.uninitialized {
+		emit(fmt.Sprintf("%s:", globalSymbol(name)))
+		emit("  .space 4")
+	}
+	emit("__baseline_bss_end:")
+	emit(".text")
+
+	for _, function := range p.functions {
+		(&armFunction{function: function}).emit()
+	}
+
+	emitRuntime()
+
+	if closures := parts.closures(env); len(closures) > 0 {
+		emit(".data")
+		emit(".balign 4")
+		for _, name := range closures {
+			emit(fmt.Sprintf("%s:", staticClosure(name)))
+			emit(fmt.Sprintf("  .word %s, 0", name))
+		}
+	}
+	env.symbols.emitShapes()
+}
+
+// armFunction emits an irFunction. Virtual registers live in the
+// callee-saved registers the allocator gave them, which the prologue
+// saves below the frame record, or else in a stack slot below those.
+// Both are where the collector looks for roots.
+type armFunction struct {
+	function     *irFunction
+	allocation   *allocation
+	next         *irBlock
+	fallsThrough bool
+	sincePool    int
+}
+
+func (a *armFunction) instruction(format string, args ...any) {
+	emit("  " + fmt.Sprintf(format, args...))
+	a.sincePool++
+}
+
+// slot returns the offset of the stack slot of value from fp.
+func (a *armFunction) slot(value irValue) int {
+	return -4 * (len(a.allocation.used) + a.allocation.slots[value] + 1)
+}
+
+// memory returns the address of the slot of value, computing it into ip
+// when the offset is out of reach of ldr and str.
+func (a *armFunction) memory(value irValue) string {
+	offset := a.slot(value)
+	if offset >= -4095 {
+		return fmt.Sprintf("[fp, #%d]", offset)
+	}
+	a.instruction("ldr ip, =%d", -offset)
+	a.instruction("sub ip, fp, ip")
+	return "[ip]"
+}
+
+// get returns a register holding value, loading it into scratch when
+// it was spilled.
+func (a *armFunction) get(value irValue, scratch string) string {
+	if home, exists := a.allocation.registers[value]; exists {
+		return home
+	}
+	a.instruction("ldr %s, %s", scratch, a.memory(value))
+	return scratch
+}
+
+// into moves value into register.
+func (a *armFunction) into(value irValue, register string) {
+	if source := a.get(value, register); source != register {
+		a.instruction("mov %s, %s", register, source)
+	}
+}
+
+// target returns the register to compute value in, which put then
+// stores if value was spilled.
+func (a *armFunction) target(value irValue, scratch string) string {
+	if home, exists := a.allocation.registers[value]; exists {
+		return home
+	}
+	return scratch
+}
+
+// put moves register, holding the result computed for value, to where
+// value lives.
+func (a *armFunction) put(value irValue, register string) {
+	if home, exists := a.allocation.registers[value]; exists {
+		if home != register {
+			a.instruction("mov %s, %s", home, register)
+		}
+		return
+	}
+	a.instruction("str %s, %s", register, a.memory(value))
+}
+
+func (a *armFunction) constant(register string, n int) {
+	if n >= 0 && n < 256 {
+		a.instruction("mov %s, #%d", register, n)
+	} else {
+		a.instruction("ldr %s, =%d", register, n)
+	}
+}
+
+func (a *armFunction) emit() {
+	f := a.function
+	f.eliminatePhis()
+	a.allocation = f.allocateRegisters()
+	saved := a.allocation.used
+	emit("")
+	if f.name[0] != '.' {
+		emit(fmt.Sprintf(".global %s", f.name))
+	}
+	emit(fmt.Sprintf("%s:", f.name))
+	a.instruction("push {fp, lr}")
+	a.instruction("mov fp, sp")
+	if len(saved) > 0 {
+		a.instruction("push {%s}", strings.Join(saved, ", "))
+	}
+	frameSize := (4*(len(saved)+len(a.allocation.slots))+7)&^7 - 4*len(saved)
+	if !armImmediate(frameSize) {
+		a.instruction("ldr ip, =%d", frameSize)
+		a.instruction("sub sp, sp, ip")
+	} else if frameSize > 0 {
+		a.instruction("sub sp, sp, #%d", frameSize)
+	}
+	if f.name == "main" {
+		// the collector stops walking frames at main
+		a.instruction("ldr r1, =__baseline_stack_base")
+		a.instruction("str fp, [r1]")
+	}
+
+	for i, block := range f.blocks {
+		a.next = nil
+		if i+1 < len(f.blocks) {
+			a.next = f.blocks[i+1]
+		}
+		if i > 0 {
+			if a.sincePool > literalPoolDistance {
+				a.pool(block.label, a.fallsThrough)
+			}
+			emit(fmt.Sprintf("%s:", block.label))
+		}
+		for _, in := range block.instructions {
+			if a.sincePool > literalPoolDistance {
+				after := NewLabel()
+				a.pool(after, true)
+				emit(fmt.Sprintf("%s:", after))
+			}
+			a.emitInstruction(in)
+		}
+	}
+	emit("  .ltorg")
+}
+
+// pool places a literal pool before label, jumping over it when the code
+// before falls through.
+func (a *armFunction) pool(label *Label, fallsThrough bool) {
+	if fallsThrough {
+		a.instruction("b %s", label)
+	}
+	emit("  .ltorg")
+	a.sincePool = 0
+}
+
+func (a *armFunction) emitInstruction(in *irInstruction) {
+	switch in.op {
+	case irConstant:
+		r := a.target(in.dst, "r0")
+		a.constant(r, in.constant)
+		a.put(in.dst, r)
+	case irCopy:
+		a.put(in.dst, a.get(in.args[0], "r0"))
+	case irParameter:
+		if in.constant < 4 {
+			a.put(in.dst, fmt.Sprintf("r%d", in.constant))
+			return
+		}
+		// stack arguments sit above the saved fp and lr
+		r := a.target(in.dst, "r0")
+		a.instruction("ldr %s, [fp, #%d]", r, 8+4*(in.constant-4))
+		a.put(in.dst, r)
+	case irAddress:
+		r := a.target(in.dst, "r0")
+		a.instruction("ldr %s, =%s", r, in.symbol)
+		a.put(in.dst, r)
+	case irAdd, irSubtract, irMultiply, irDivide, irRemainder, irEqual, irNotEqual:
+		a.emitBinary(in)
+	case irNegate:
+		x := a.get(in.args[0], "r0")
+		r := a.target(in.dst, "r0")
+		a.instruction("rsb %s, %s, #0", r, x)
+		a.put(in.dst, r)
+	case irNot:
+		x := a.get(in.args[0], "r0")
+		r := a.target(in.dst, "r0")
+		a.instruction("cmp %s, #0", x)
+		a.instruction("moveq %s, #1", r)
+		a.instruction("movne %s, #0", r)
+		a.put(in.dst, r)
+	case irLoad:
+		base := a.get(in.args[0], "r0")
+		r := a.target(in.dst, "r0")
+		a.instruction("ldr %s, [%s, #%d]", r, base, in.constant)
+		a.put(in.dst, r)
+	case irStore:
+		base := a.get(in.args[0], "r0")
+		value := a.get(in.args[1], "r1")
+		a.instruction("str %s, [%s, #%d]", value, base, in.constant)
+	case irAlloc:
+		a.constant("r0", in.constant)
+		a.instruction("bl %s", allocFunction)
+		a.put(in.dst, "r0")
+	case irField:
+		a.into(in.args[0], "r0")
+		a.constant("r1", in.constant)
+		a.instruction("bl %s", fieldFunction)
+		a.put(in.dst, "r0")
+	case irCall:
+		stack := a.reserve(len(in.args))
+		a.arguments(in.args)
+		a.instruction("bl %s", in.symbol)
+		a.release(stack)
+		a.put(in.dst, "r0")
+	case irCallClosure:
+		a.emitCallClosure(in)
+	case irJump:
+		a.jump(in.targets[0])
+	case irBranch:
+		x := a.get(in.args[0], "r0")
+		a.instruction("cmp %s, #0", x)
+		if in.targets[0] == a.next {
+			a.instruction("beq %s", in.targets[1].label)
+			a.fallsThrough = true
+		} else {
+			a.instruction("bne %s", in.targets[0].label)
+			a.jump(in.targets[1])
+		}
+	case irSwitch:
+		a.emitSwitch(in)
+	case irReturn:
+		a.into(in.args[0], "r0")
+		if saved := a.allocation.used; len(saved) > 0 {
+			// the saved registers sit right below the frame record
+			a.instruction("sub sp, fp, #%d", 4*len(saved))
+			a.instruction("pop {%s, fp, pc}", strings.Join(saved, ", "))
+		} else {
+			a.instruction("mov sp, fp")
+			a.instruction("pop {fp, pc}")
+		}
+		a.fallsThrough = false
+	default:
+		panic(fmt.Sprintf("the ARM backend cannot emit %s", in))
+	}
+}
+
+// jump continues at block, falling through when it comes next.
+func (a *armFunction) jump(block *irBlock) {
+	a.fallsThrough = block == a.next
+	if !a.fallsThrough {
+		a.instruction("b %s", block.label)
+	}
+}
+
+func (a *armFunction) emitBinary(in *irInstruction) {
+	if !hardwareDivide && (in.op == irDivide || in.op == irRemainder) {
+		// the quotient ends up in r0 and the remainder in r1
+		a.into(in.args[1], "r0")
+		a.into(in.args[0], "r1")
+		emitDivision()
+		if in.op == irDivide {
+			a.put(in.dst, "r0")
+		} else {
+			a.put(in.dst, "r1")
+		}
+		return
+	}
+
+	x := a.get(in.args[0], "r0")
+	y := a.get(in.args[1], "r1")
+	r := a.target(in.dst, "r0")
+	switch in.op {
+	case irAdd:
+		a.instruction("add %s, %s, %s", r, x, y)
+	case irSubtract:
+		a.instruction("sub %s, %s, %s", r, x, y)
+	case irMultiply:
+		a.instruction("mul %s, %s, %s", r, x, y)
+	case irDivide:
+		a.instruction("sdiv %s, %s, %s", r, x, y)
+	case irRemainder:
+		a.instruction("sdiv ip, %s, %s", x, y)
+		a.instruction("mls %s, ip, %s, %s", r, y, x)
+	case irEqual, irNotEqual:
+		a.instruction("cmp %s, %s", x, y)
+		if in.op == irEqual {
+			a.instruction("moveq %s, #1", r)
+			a.instruction("movne %s, #0", r)
+		} else {
+			a.instruction("movne %s, #1", r)
+			a.instruction("moveq %s, #0", r)
+		}
+	}
+	a.put(in.dst, r)
+}
+
+// reserve makes room for the arguments of a call past the fourth, padded
+// so that sp stays 8-byte aligned, and returns the size of the area.
+func (a *armFunction) reserve(count int) int {
+	if count <= 4 {
+		return 0
+	}
+	stack := (4*(count-4) + 7) &^ 7
+	a.instruction("sub sp, sp, #%d", stack)
+	return stack
+}
+
+// arguments passes the first four arguments in r0 to r3 and the rest in
+// the area reserve made.
+func (a *armFunction) arguments(args []irValue) {
+	for i := 4; i < len(args); i++ {
+		a.instruction("str %s, [sp, #%d]", a.get(args[i], "r0"), 4*(i-4))
+	}
+	for i := 0; i < min(4, len(args)); i++ {
+		a.into(args[i], fmt.Sprintf("r%d", i))
+	}
+}
+
+func (a *armFunction) release(stack int) {
+	if stack > 0 {
+		a.instruction("add sp, sp, #%d", stack)
+	}
+}
+
+// emitCallClosure passes the closure's environment after the declared
+// arguments, where functions that do not expect it simply ignore it. lr
+// holds the closure until the call overwrites it.
+func (a *armFunction) emitCallClosure(in *irInstruction) {
+	args := in.args[1:]
+	environment := len(args)
+	stack := a.reserve(environment + 1)
+	a.arguments(args)
+	a.into(in.args[0], "lr")
+	if environment < 4 {
+		a.instruction("ldr r%d, [lr, #4]", environment)
+	} else {
+		a.instruction("ldr ip, [lr, #4]")
+		a.instruction("str ip, [sp, #%d]", 4*(environment-4))
+	}
+	a.instruction("ldr ip, [lr]")
+	a.instruction("blx ip")
+	a.release(stack)
+	a.put(in.dst, "r0")
+}
+
+func (a *armFunction) emitSwitch(in *irInstruction) {
+	a.into(in.args[0], "r0")
+	plan := switchPlan{values: in.cases, target: in.targets[len(in.cases)].label}
+	for _, block := range in.targets[:len(in.cases)] {
+		plan.caseLabels = append(plan.caseLabels, block.label)
+	}
+	if isDense(plan.values) {
+		low, table := plan.table()
+		arm{}.emitJumpTable(low, table, plan.target)
+		a.sincePool += len(plan.values) + 6
+		a.fallsThrough = false
+		return
+	}
+	for i, value := range plan.values {
+		a.constant("r1", value)
+		a.instruction("cmp r0, r1")
+		a.instruction("beq %s", plan.caseLabels[i])
+	}
+	a.jump(in.targets[len(in.cases)])
+}
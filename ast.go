@@ -1,24 +1,103 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 var emit = fmt.Println
 
+// Environment is one lexical scope. Nested blocks get a child scope whose
+// slots continue below the parent's, so sibling blocks reuse the same
+// frame offsets once the earlier block has ended.
+//
+// Names that are not found in any scope fall back to the variables
+// captured by the enclosing closure and then to the program's symbols.
+// Locals listed in boxed are captured by some closure, so their slot holds
+// a pointer to a heap cell instead of the value itself.
 type Environment struct {
 	locals          map[string]int
+	parent          *Environment
+	symbols         *symbols
+	boxed           map[string]bool
+	captures        map[string]int
 	nextLocalOffset int
+	loops           []loopLabels
+}
+
+// symbols are the top-level names of a program, shared by all of its
+// environments. closures records the functions used as values, which need
+// a static closure object. fields holds the layout indexes the type
+// checker determined, keyed by the position of the field access.
+type symbols struct {
+	globals    map[string]bool
+	functions  map[string]bool
+	closures   map[string]bool
+	fields     map[Position]int
+	shapes     map[string]string
+	shapeOrder []string
+	fieldIds   map[string]int
+}
+
+// loopLabels are the targets of break and continue in an enclosing loop or
+// switch. A switch outside of any loop has no continue target.
+type loopLabels struct {
+	breakLabel, continueLabel *Label
+}
+
+func (env *Environment) innermostLoop() (loopLabels, bool) {
+	if len(env.loops) == 0 {
+		return loopLabels{}, false
+	}
+	return env.loops[len(env.loops)-1], true
 }
 
 func NewEnvironment() *Environment {
 	return &Environment{
-		locals:          make(map[string]int),
+		locals: make(map[string]int),
+		symbols: &symbols{
+			globals:   make(map[string]bool),
+			functions: make(map[string]bool),
+			closures:  make(map[string]bool),
+			fields:    make(map[Position]int),
+			shapes:    make(map[string]string),
+			fieldIds:  make(map[string]int),
+		},
 		nextLocalOffset: 0,
 	}
 }
 
+func (env *Environment) scope() *Environment {
+	return &Environment{
+		locals:          make(map[string]int),
+		parent:          env,
+		symbols:         env.symbols,
+		boxed:           env.boxed,
+		captures:        env.captures,
+		nextLocalOffset: env.nextLocalOffset,
+		loops:           env.loops,
+	}
+}
+
+func (env *Environment) lookup(name string) (int, bool) {
+	for scope := env; scope != nil; scope = scope.parent {
+		if offset, exists := scope.locals[name]; exists {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// isVariable reports whether name refers to a variable rather than to a
+// top-level or external function.
+func (env *Environment) isVariable(name string) bool {
+	_, local := env.lookup(name)
+	_, captured := env.captures[name]
+	return local || captured || env.symbols.globals[name]
+}
+
 // AST Interface and Implementations
 type AST interface {
-	Emit(env *Environment)
 	Equals(other AST) bool
 }
 
@@ -26,10 +105,6 @@ type Number struct {
 	value int
 }
 
-func (n Number) Emit(env *Environment) {
-	emit(fmt.Sprintf("  ldr r0, =%d", n.value))
-}
-
 func (n Number) Equals(other AST) bool {
 	if otherNum, ok := other.(*Number); ok {
 		return n.value == otherNum.value
@@ -37,16 +112,20 @@ func (n Number) Equals(other AST) bool {
 	return false
 }
 
-type Id struct {
-	value string
+type Boolean struct {
+	value bool
 }
 
-func (i Id) Emit(env *Environment) {
-	if offset, exists := env.locals[i.value]; exists {
-		emit(fmt.Sprintf("  ldr r0, [fp, #%d]", offset))
-	} else {
-		panic(fmt.Sprintf("Undefined variable: %s", i.value))
+func (b Boolean) Equals(other AST) bool {
+	if otherBool, ok := other.(*Boolean); ok {
+		return b.value == otherBool.value
 	}
+	return false
+}
+
+type Id struct {
+	value string
+	pos   Position
 }
 
 func (i Id) Equals(other AST) bool {
@@ -60,13 +139,6 @@ type Not struct {
 	term AST
 }
 
-func (n Not) Emit(env *Environment) {
-	n.term.Emit(env)
-	emit("  cmp r0, #0")
-	emit("  moveq r0, #1")
-	emit("  movne r0, #0")
-}
-
 func (n Not) Equals(other AST) bool {
 	if otherNot, ok := other.(*Not); ok {
 		return n.term.Equals(otherNot.term)
@@ -74,18 +146,19 @@ func (n Not) Equals(other AST) bool {
 	return false
 }
 
-type Equal struct {
-	left, right AST
+type Negate struct {
+	term AST
 }
 
-func (e Equal) Emit(env *Environment) {
-	e.left.Emit(env)
-	emit("  push {r0, ip}")
-	e.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  cmp r0, r1")
-	emit("  moveq r0, #1")
-	emit("  movne r0, #0")
+func (n Negate) Equals(other AST) bool {
+	if otherNegate, ok := other.(*Negate); ok {
+		return n.term.Equals(otherNegate.term)
+	}
+	return false
+}
+
+type Equal struct {
+	left, right AST
 }
 
 func (e Equal) Equals(other AST) bool {
@@ -99,16 +172,6 @@ type NotEqual struct {
 	left, right AST
 }
 
-func (ne NotEqual) Emit(env *Environment) {
-	ne.left.Emit(env)
-	emit("  push {r0, ip}")
-	ne.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  cmp r0, r1")
-	emit("  movne r0, #1")
-	emit("  moveq r0, #0")
-}
-
 func (ne NotEqual) Equals(other AST) bool {
 	if otherNotEqual, ok := other.(*NotEqual); ok {
 		return ne.left.Equals(otherNotEqual.left) && ne.right.Equals(otherNotEqual.right)
@@ -120,14 +183,6 @@ type Add struct {
 	left, right AST
 }
 
-func (a Add) Emit(env *Environment) {
-	a.left.Emit(env)
-	emit("  push {r0, ip}")
-	a.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  add r0, r1, r0")
-}
-
 func (a Add) Equals(other AST) bool {
 	if otherAdd, ok := other.(*Add); ok {
 		return a.left.Equals(otherAdd.left) && a.right.Equals(otherAdd.right)
@@ -139,14 +194,6 @@ type Subtract struct {
 	left, right AST
 }
 
-func (s Subtract) Emit(env *Environment) {
-	s.left.Emit(env)
-	emit("  push {r0, ip}")
-	s.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  sub r0, r1, r0")
-}
-
 func (s Subtract) Equals(other AST) bool {
 	if otherSub, ok := other.(*Subtract); ok {
 		return s.left.Equals(otherSub.left) && s.right.Equals(otherSub.right)
@@ -158,14 +205,6 @@ type Multiply struct {
 	left, right AST
 }
 
-func (m Multiply) Emit(env *Environment) {
-	m.left.Emit(env)
-	emit("  push {r0, ip}")
-	m.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  mul r0, r1, r0")
-}
-
 func (m Multiply) Equals(other AST) bool {
 	if otherMul, ok := other.(*Multiply); ok {
 		return m.left.Equals(otherMul.left) && m.right.Equals(otherMul.right)
@@ -177,14 +216,6 @@ type Divide struct {
 	left, right AST
 }
 
-func (d Divide) Emit(env *Environment) {
-	d.left.Emit(env)
-	emit("  push {r0, ip}")
-	d.right.Emit(env)
-	emit("  pop {r1, ip}")
-	emit("  udiv r0, r1, r0")
-}
-
 func (d Divide) Equals(other AST) bool {
 	if otherDiv, ok := other.(*Divide); ok {
 		return d.left.Equals(otherDiv.left) && d.right.Equals(otherDiv.right)
@@ -192,29 +223,21 @@ func (d Divide) Equals(other AST) bool {
 	return false
 }
 
-type Call struct {
-	callee string
-	args   []AST
+type Modulo struct {
+	left, right AST
 }
 
-func (c Call) Emit(env *Environment) {
-	count := len(c.args)
-	if count == 0 {
-		emit(fmt.Sprintf("  bl %s", c.callee))
-	} else if count == 1 {
-		c.args[0].Emit(env)
-		emit(fmt.Sprintf("  bl %s", c.callee))
-	} else if count >= 2 && count <= 4 {
-		emit("  sub sp, sp, #16")
-		for i, arg := range c.args {
-			arg.Emit(env)
-			emit(fmt.Sprintf("  str r0, [sp, #%d]", 4*i))
-		}
-		emit("  pop {r0, r1, r2, r3}")
-		emit(fmt.Sprintf("  bl %s", c.callee))
-	} else {
-		panic("More than 4 arguments are not supported")
+func (m Modulo) Equals(other AST) bool {
+	if otherMod, ok := other.(*Modulo); ok {
+		return m.left.Equals(otherMod.left) && m.right.Equals(otherMod.right)
 	}
+	return false
+}
+
+type Call struct {
+	callee string
+	args   []AST
+	pos    Position
 }
 
 func (c Call) Equals(other AST) bool {
@@ -234,12 +257,7 @@ func (c Call) Equals(other AST) bool {
 
 type Return struct {
 	term AST
-}
-
-func (r Return) Emit(env *Environment) {
-	r.term.Emit(env)
-	emit("  mov sp, fp")
-	emit("  pop {fp, pc}")
+	pos  Position
 }
 
 func (r Return) Equals(other AST) bool {
@@ -253,12 +271,6 @@ type Block struct {
 	statements []AST
 }
 
-func (b Block) Emit(env *Environment) {
-	for _, statement := range b.statements {
-		statement.Emit(env)
-	}
-}
-
 func (b Block) Equals(other AST) bool {
 	if otherBlock, ok := other.(*Block); ok {
 		if len(b.statements) != len(otherBlock.statements) {
@@ -274,29 +286,16 @@ func (b Block) Equals(other AST) bool {
 	return false
 }
 
+// If has a nil alternative when the else branch is omitted.
 type If struct {
 	conditional, consequence, alternative AST
 }
 
-func (i If) Emit(env *Environment) {
-	ifFalseLabel := NewLabel()
-	endIfLabel := NewLabel()
-
-	i.conditional.Emit(env)
-	emit("  cmp r0, #0")
-	emit(fmt.Sprintf("  beq %s", ifFalseLabel))
-	i.consequence.Emit(env)
-	emit(fmt.Sprintf("  b %s", endIfLabel))
-	emit(fmt.Sprintf("%s:", ifFalseLabel))
-	i.alternative.Emit(env)
-	emit(fmt.Sprintf("%s:", endIfLabel))
-}
-
 func (i If) Equals(other AST) bool {
 	if otherIf, ok := other.(*If); ok {
 		return i.conditional.Equals(otherIf.conditional) &&
 			i.consequence.Equals(otherIf.consequence) &&
-			i.alternative.Equals(otherIf.alternative)
+			equalOrNil(i.alternative, otherIf.alternative)
 	}
 	return false
 }
@@ -305,19 +304,6 @@ type While struct {
 	conditional, body AST
 }
 
-func (w While) Emit(env *Environment) {
-	loopStart := NewLabel()
-	loopEnd := NewLabel()
-
-	emit(fmt.Sprintf("%s:", loopStart))
-	w.conditional.Emit(env)
-	emit("  cmp r0, #0")
-	emit(fmt.Sprintf("  beq %s", loopEnd))
-	w.body.Emit(env)
-	emit(fmt.Sprintf("  b %s", loopStart))
-	emit(fmt.Sprintf("%s:", loopEnd))
-}
-
 func (w While) Equals(other AST) bool {
 	if otherWhile, ok := other.(*While); ok {
 		return w.conditional.Equals(otherWhile.conditional) && w.body.Equals(otherWhile.body)
@@ -325,18 +311,44 @@ func (w While) Equals(other AST) bool {
 	return false
 }
 
-type Assign struct {
-	name  string
-	value AST
+// For has a nil init, conditional or step when the clause is omitted;
+// a missing conditional loops until break.
+type For struct {
+	init, conditional, step, body AST
 }
 
-func (a Assign) Emit(env *Environment) {
-	a.value.Emit(env)
-	if offset, exists := env.locals[a.name]; exists {
-		emit(fmt.Sprintf("  str r0, [fp, #%d]", offset))
-	} else {
-		panic(fmt.Sprintf("Undefined variable: %s", a.name))
+func (f For) Equals(other AST) bool {
+	if otherFor, ok := other.(*For); ok {
+		return equalOrNil(f.init, otherFor.init) &&
+			equalOrNil(f.conditional, otherFor.conditional) &&
+			equalOrNil(f.step, otherFor.step) &&
+			f.body.Equals(otherFor.body)
 	}
+	return false
+}
+
+type Break struct {
+	pos Position
+}
+
+func (b Break) Equals(other AST) bool {
+	_, ok := other.(*Break)
+	return ok
+}
+
+type Continue struct {
+	pos Position
+}
+
+func (c Continue) Equals(other AST) bool {
+	_, ok := other.(*Continue)
+	return ok
+}
+
+type Assign struct {
+	name  string
+	value AST
+	pos   Position
 }
 
 func (a Assign) Equals(other AST) bool {
@@ -346,16 +358,17 @@ func (a Assign) Equals(other AST) bool {
 	return false
 }
 
+// Var has a nil typ when the declaration is not annotated.
 type Var struct {
 	name  string
+	typ   Type
 	value AST
+	pos   Position
 }
 
-func (v Var) Emit(env *Environment) {
-	v.value.Emit(env)
-	emit("  push {r0, ip}")
-	env.locals[v.name] = env.nextLocalOffset - 4
-	env.nextLocalOffset -= 8
+func isRecursive(v Var) bool {
+	_, ok := v.value.(FunctionExpression)
+	return ok
 }
 
 func (v Var) Equals(other AST) bool {
@@ -368,43 +381,9 @@ func (v Var) Equals(other AST) bool {
 type Function struct {
 	name       string
 	parameters []string
+	signature  FunctionType
 	body       AST
-}
-
-func (f Function) Emit(env *Environment) {
-	if len(f.parameters) > 4 {
-		panic("More than 4 params is not supported")
-	}
-
-	emit("")
-	emit(fmt.Sprintf(".global %s", f.name))
-	emit(fmt.Sprintf("%s:", f.name))
-
-	f.emitPrologue()
-	funcEnv := f.setUpEnvironment()
-	f.body.Emit(funcEnv)
-	f.emitEpilogue()
-}
-
-func (f Function) emitPrologue() {
-	emit("  push {fp, lr}")
-	emit("  mov fp, sp")
-	emit("  push {r0, r1, r2, r3}")
-}
-
-func (f Function) setUpEnvironment() *Environment {
-	env := NewEnvironment()
-	for i, param := range f.parameters {
-		env.locals[param] = 4*i - 16
-	}
-	env.nextLocalOffset = -20
-	return env
-}
-
-func (f Function) emitEpilogue() {
-	emit("  mov sp, fp")
-	emit("  mov r0, #0")
-	emit("  pop {fp, pc}")
+	pos        Position
 }
 
 func (f Function) Equals(other AST) bool {
@@ -426,17 +405,6 @@ type Main struct {
 	statements []AST
 }
 
-func (m Main) Emit(env *Environment) {
-	emit(".global main")
-	emit("main:")
-	emit("  push {fp, lr}")
-	for _, statement := range m.statements {
-		statement.Emit(env)
-	}
-	emit("  mov r0, #0")
-	emit("  pop {fp, pc}")
-}
-
 func (m Main) Equals(other AST) bool {
 	if otherMain, ok := other.(*Main); ok {
 		if len(m.statements) != len(otherMain.statements) {
@@ -452,16 +420,154 @@ func (m Main) Equals(other AST) bool {
 	return false
 }
 
-type Assert struct {
-	condition AST
+// initFunction runs the top-level statements of a program; main calls it
+// before its own body.
+const initFunction = "__baseline_init"
+
+// Extern declares a function defined outside the program, such as one from
+// libc, so that calls to it can be checked. It emits nothing where it is
+// declared; the program lists it with .extern.
+type Extern struct {
+	name       string
+	parameters []string
+	signature  FunctionType
+	pos        Position
+}
+
+func (e Extern) Equals(other AST) bool {
+	if otherExtern, ok := other.(*Extern); ok {
+		return e.name == otherExtern.name && len(e.parameters) == len(otherExtern.parameters)
+	}
+	return false
+}
+
+// misplaced is the error for a declaration below the top level, where
+// the parser puts no function and the linker and the type checker reject
+// the others.
+func misplaced(node AST) string {
+	switch node := node.(type) {
+	case Function:
+		return fmt.Sprintf("%s: function %s must be at the top level", node.pos, node.name)
+	case Extern:
+		return fmt.Sprintf("%s: extern must be at the top level", node.pos)
+	case Import:
+		return fmt.Sprintf("%s: import must be at the top level of a module", node.pos)
+	case Export:
+		return fmt.Sprintf("%s: export must be at the top level of a module", node.pos)
+	default:
+		return fmt.Sprintf("cannot compile %T here", node)
+	}
+}
+
+// globalSymbol returns the assembler symbol of a global variable. It is
+// local to the object file, so it cannot clash with a function or with a
+// symbol of libc or the runtime.
+func globalSymbol(name string) string {
+	return ".Lglobal_" + name
+}
+
+// Program is the whole source file. Top-level variables become globals in
+// .data, or in .bss when their initializer is not a constant, and every
+// top-level statement other than a function runs in initFunction. An
+// extern may be declared more than once, as modules linked together may
+// each declare the same libc function.
+type Program struct {
+	statements []AST
+}
+
+// programParts are the top-level statements of a program sorted by how
+// they are emitted.
+type programParts struct {
+	functions      []AST
+	initialized    []Var
+	uninitialized  []string
+	initStatements []AST
+	externs        []string
+}
+
+// split sorts the top-level statements and declares their names in the
+// program's symbols.
+func (p Program) split(env *Environment) programParts {
+	parts := programParts{}
+	declared := map[string]bool{}
+
+	declare := func(name string) {
+		if declared[name] {
+			panic(fmt.Sprintf("Duplicate top-level declaration: %s", name))
+		}
+		declared[name] = true
+	}
+
+	for _, statement := range p.statements {
+		switch statement := statement.(type) {
+		case Function:
+			declare(statement.name)
+			env.symbols.functions[statement.name] = true
+			parts.functions = append(parts.functions, statement)
+		case Main:
+			declare("main")
+			parts.functions = append(parts.functions, statement)
+		case Extern:
+			if !slices.Contains(parts.externs, statement.name) {
+				declare(statement.name)
+				env.symbols.functions[statement.name] = true
+				parts.externs = append(parts.externs, statement.name)
+			}
+		case Var:
+			declare(statement.name)
+			env.symbols.globals[statement.name] = true
+			if number, ok := statement.value.(Number); ok {
+				parts.initialized = append(parts.initialized, Var{name: statement.name, value: number})
+			} else {
+				parts.uninitialized = append(parts.uninitialized, statement.name)
+				parts.initStatements = append(parts.initStatements, Assign{name: statement.name, value: statement.value})
+			}
+		default:
+			parts.initStatements = append(parts.initStatements, statement)
+		}
+	}
+	return parts
+}
+
+// init is the function running the top-level statements.
+func (parts programParts) init() Function {
+	return Function{name: initFunction, parameters: []string{}, body: Block{statements: parts.initStatements}}
+}
+
+// closures returns the functions used as values, which need a static
+// closure, in the order they were declared.
+func (parts programParts) closures(env *Environment) []string {
+	names := []string{}
+	for _, name := range parts.externs {
+		if env.symbols.closures[name] {
+			names = append(names, name)
+		}
+	}
+	for _, function := range parts.functions {
+		if function, ok := function.(Function); ok && env.symbols.closures[function.name] {
+			names = append(names, function.name)
+		}
+	}
+	return names
+}
+
+func (p Program) Equals(other AST) bool {
+	if otherProgram, ok := other.(*Program); ok {
+		if len(p.statements) != len(otherProgram.statements) {
+			return false
+		}
+		for i, stmt := range p.statements {
+			if !stmt.Equals(otherProgram.statements[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
-func (a Assert) Emit(env *Environment) {
-	a.condition.Emit(env)
-	emit("  cmp r0, #1")
-	emit("  moveq r0, #'.'")
-	emit("  movne r0, #'F'")
-	emit("  bl putchar")
+type Assert struct {
+	condition AST
 }
 
 func (a Assert) Equals(other AST) bool {
@@ -471,6 +577,127 @@ func (a Assert) Equals(other AST) bool {
 	return false
 }
 
+// children returns the direct subtrees of node.
+func children(node AST) []AST {
+	switch node := node.(type) {
+	case Not:
+		return []AST{node.term}
+	case Negate:
+		return []AST{node.term}
+	case Equal:
+		return []AST{node.left, node.right}
+	case NotEqual:
+		return []AST{node.left, node.right}
+	case Add:
+		return []AST{node.left, node.right}
+	case Subtract:
+		return []AST{node.left, node.right}
+	case Multiply:
+		return []AST{node.left, node.right}
+	case Divide:
+		return []AST{node.left, node.right}
+	case Modulo:
+		return []AST{node.left, node.right}
+	case Call:
+		return node.args
+	case Return:
+		return []AST{node.term}
+	case Block:
+		return node.statements
+	case If:
+		return nonNil(node.conditional, node.consequence, node.alternative)
+	case While:
+		return []AST{node.conditional, node.body}
+	case For:
+		return nonNil(node.init, node.conditional, node.step, node.body)
+	case Assign:
+		return []AST{node.value}
+	case Var:
+		return []AST{node.value}
+	case Function:
+		return []AST{node.body}
+	case FunctionExpression:
+		return []AST{node.body}
+	case Main:
+		return node.statements
+	case Program:
+		return node.statements
+	case Assert:
+		return []AST{node.condition}
+	case Record:
+		values := []AST{}
+		for _, field := range node.fields {
+			values = append(values, field.value)
+		}
+		return values
+	case Member:
+		return []AST{node.object}
+	case MemberAssign:
+		return []AST{node.object, node.value}
+	case Switch:
+		return append([]AST{node.value}, node.statements()...)
+	default:
+		return nil
+	}
+}
+
+func nonNil(nodes ...AST) []AST {
+	result := []AST{}
+	for _, node := range nodes {
+		if node != nil {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// localSlots counts the stack slots needed by the variables declared in
+// node, mirroring the scopes that Emit creates: slots of a finished block
+// are reused by the statements after it.
+func localSlots(node AST) int {
+	switch node := node.(type) {
+	case Var:
+		return 1
+	case Block:
+		declared, peak := 0, 0
+		for _, statement := range node.statements {
+			if _, ok := statement.(Var); ok {
+				declared++
+				peak = max(peak, declared)
+			} else {
+				peak = max(peak, declared+localSlots(statement))
+			}
+		}
+		return peak
+	case If:
+		if node.alternative == nil {
+			return localSlots(node.consequence)
+		}
+		return max(localSlots(node.consequence), localSlots(node.alternative))
+	case While:
+		return localSlots(node.body)
+	case Switch:
+		return localSlots(Block{statements: node.statements()})
+	case For:
+		// the body's variables are gone by the time the step runs
+		inner := max(localSlots(node.body), localSlots(node.step))
+		if node.init == nil {
+			return inner
+		}
+		return localSlots(node.init) + inner
+	default:
+		return 0
+	}
+}
+
+// equalOrNil compares optional children such as a missing else branch.
+func equalOrNil(a, b AST) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equals(b)
+}
+
 // Label implementation
 type Label struct {
 	value int
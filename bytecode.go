@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// opcode is an instruction of the bytecode virtual machine, which works
+// on a stack of int32 values. Each function has its own numbered locals,
+// its parameters first, and objects live in a heap of words referred to
+// by index, laid out as on ARM.
+type opcode byte
+
+const (
+	opConstant    opcode = iota // push the operand
+	opLoad                      // push local operand
+	opStore                     // pop into local operand
+	opLoadGlobal                // push global operand
+	opStoreGlobal               // pop into global operand
+	opLoadHeap                  // pop an address, push the word operand words past it
+	opStoreHeap                 // pop a value and an address, store the value operand words past it
+	opAlloc                     // push the address of operand zeroed words
+	opBox                       // pop a value, push the address of a new cell holding it
+	opField                     // pop a record, push the address of its field with id operand
+	opClosure                   // pop an environment, push a closure of function operand
+	opDuplicate                 // push the top of the stack again
+	opPop                       // drop the top of the stack
+	opAdd                       // pop b and a, push a + b
+	opSubtract                  // pop b and a, push a - b
+	opMultiply                  // pop b and a, push a * b
+	opDivide                    // pop b and a, push a / b, or 0 when b is 0
+	opRemainder                 // pop b and a, push a % b, or a when b is 0
+	opNegate                    // replace the top of the stack with its negation
+	opNot                       // replace the top of the stack with 1 if it is 0, else 0
+	opEqual                     // pop b and a, push 1 if a == b, else 0
+	opNotEqual                  // pop b and a, push 1 if a != b, else 0
+	opJump                      // continue at instruction operand
+	opJumpIfZero                // pop a value, continue at instruction operand if it is 0
+	opCall                      // call function operand with its arguments on the stack
+	opCallExtern                // call extern operand with its arguments on the stack
+	opCallClosure               // pop a closure, call it with operand arguments and its environment
+	opReturn                    // return the top of the stack to the caller
+	opcodeCount
+)
+
+// hasOperand reports whether an instruction is encoded with an operand.
+func (op opcode) hasOperand() bool {
+	switch op {
+	case opBox, opDuplicate, opPop, opAdd, opSubtract, opMultiply, opDivide, opRemainder,
+		opNegate, opNot, opEqual, opNotEqual, opReturn:
+		return false
+	}
+	return true
+}
+
+type instruction struct {
+	op      opcode
+	operand int32
+}
+
+type bytecodeFunction struct {
+	name       string
+	parameters int
+	locals     int
+	code       []instruction
+}
+
+type bytecodeExtern struct {
+	name       string
+	parameters int
+}
+
+// bytecodeModule is a compiled program. heap holds the initial heap: the
+// closures of top-level functions used as values and the record shapes.
+// A closure's code is a function index, or -1 - i for extern i. main is
+// the index of the main function, or -1.
+type bytecodeModule struct {
+	externs   []bytecodeExtern
+	functions []bytecodeFunction
+	globals   []int32
+	heap      []int32
+	main      int
+}
+
+// bytecodeMagic and bytecodeVersion start a .bbc file. The version
+// changes whenever the encoding or the meaning of an opcode does.
+const (
+	bytecodeMagic   = "BBC\x00"
+	bytecodeVersion = 1
+)
+
+// bytecodeCompiler compiles a program into a bytecodeModule.
+type bytecodeCompiler struct {
+	module    *bytecodeModule
+	functions map[string]int
+	externs   map[string]int
+	globals   map[string]int
+	closures  map[string]int32
+	shapes    map[string]int32
+}
+
+// bytecodeBuilder is a function being compiled. Jumps to labels not yet
+// placed are patched when the function is finished.
+type bytecodeBuilder struct {
+	function *bytecodeFunction
+	labels   map[*Label]int
+	fixups   map[int]*Label
+}
+
+func (b *bytecodeBuilder) emit(op opcode, operand int32) {
+	b.function.code = append(b.function.code, instruction{op: op, operand: operand})
+}
+
+func (b *bytecodeBuilder) jump(op opcode, label *Label) {
+	b.fixups[len(b.function.code)] = label
+	b.emit(op, 0)
+}
+
+func (b *bytecodeBuilder) place(label *Label) {
+	b.labels[label] = len(b.function.code)
+}
+
+func (b *bytecodeBuilder) local() int {
+	b.function.locals++
+	return b.function.locals - 1
+}
+
+// compileBytecode compiles a linked, checked program.
+func compileBytecode(node AST, env *Environment) *bytecodeModule {
+	program, ok := node.(Program)
+	if !ok {
+		panic(fmt.Sprintf("cannot compile %T outside of a program", node))
+	}
+	c := &bytecodeCompiler{
+		module:    &bytecodeModule{heap: []int32{0}, main: -1},
+		functions: make(map[string]int),
+		externs:   make(map[string]int),
+		globals:   make(map[string]int),
+		closures:  make(map[string]int32),
+		shapes:    make(map[string]int32),
+	}
+	parts := program.split(env)
+	c.extern("putchar", 1)
+	for _, statement := range program.statements {
+		if extern, ok := statement.(Extern); ok {
+			c.extern(extern.name, len(extern.parameters))
+		}
+	}
+	for _, global := range parts.initialized {
+		c.globals[global.name] = len(c.module.globals)
+		c.module.globals = append(c.module.globals, int32(global.value.(Number).value))
+	}
+	for _, name := range parts.uninitialized {
+		c.globals[name] = len(c.module.globals)
+		c.module.globals = append(c.module.globals, 0)
+	}
+
+	init := parts.init()
+	functions := []Function{init}
+	for _, function := range parts.functions {
+		switch function := function.(type) {
+		case Function:
+			functions = append(functions, function)
+		case Main:
+			functions = append(functions, Function{name: "main", parameters: []string{}, body: Block{statements: function.statements}})
+		}
+	}
+	for _, function := range functions {
+		c.functions[function.name] = len(c.module.functions)
+		c.module.functions = append(c.module.functions, bytecodeFunction{name: function.name, parameters: len(function.parameters)})
+	}
+	if index, exists := c.functions["main"]; exists {
+		c.module.main = index
+	}
+	for _, function := range functions {
+		c.function(c.functions[function.name], function, env, nil)
+	}
+	return c.module
+}
+
+func (c *bytecodeCompiler) extern(name string, parameters int) {
+	if _, exists := c.externs[name]; !exists {
+		c.externs[name] = len(c.module.externs)
+		c.module.externs = append(c.module.externs, bytecodeExtern{name: name, parameters: parameters})
+	}
+}
+
+// word allocates words in the initial heap and returns their address.
+func (c *bytecodeCompiler) word(words ...int32) int32 {
+	address := int32(len(c.module.heap))
+	c.module.heap = append(c.module.heap, words...)
+	return address
+}
+
+func (c *bytecodeCompiler) shape(names []string, env *Environment) int32 {
+	names = layout(names)
+	key := strings.Join(names, ",")
+	if address, exists := c.shapes[key]; exists {
+		return address
+	}
+	words := []int32{int32(len(names))}
+	for _, name := range names {
+		words = append(words, int32(env.symbols.fieldId(name)))
+	}
+	c.shapes[key] = c.word(words...)
+	return c.shapes[key]
+}
+
+// staticClosure returns the address of the closure of a top-level or
+// external function. Its environment is passed as an extra argument,
+// which the function does not take.
+func (c *bytecodeCompiler) staticClosure(name string) int32 {
+	if address, exists := c.closures[name]; exists {
+		return address
+	}
+	code, isFunction := c.functions[name]
+	if !isFunction {
+		code = -1 - c.externs[name]
+	}
+	c.closures[name] = c.word(int32(code), 0)
+	return c.closures[name]
+}
+
+// function compiles a function into the module's function index. captures
+// numbers the variables in the environment of a closure.
+func (c *bytecodeCompiler) function(index int, function Function, outer *Environment, captures map[string]int) {
+	b := &bytecodeBuilder{
+		function: &bytecodeFunction{name: function.name, parameters: len(function.parameters)},
+		labels:   make(map[*Label]int),
+		fixups:   make(map[int]*Label),
+	}
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(function.body)
+	env.captures = captures
+	for _, param := range function.parameters {
+		env.locals[param] = b.local()
+	}
+	for _, param := range function.parameters {
+		if env.boxed[param] {
+			b.emit(opLoad, int32(env.locals[param]))
+			b.emit(opBox, 0)
+			b.emit(opStore, int32(env.locals[param]))
+		}
+	}
+	if function.name == "main" {
+		b.emit(opCall, int32(c.functions[initFunction]))
+		b.emit(opPop, 0)
+	}
+	c.statement(function.body, env, b)
+	b.emit(opConstant, 0)
+	b.emit(opReturn, 0)
+
+	for at, label := range b.fixups {
+		b.function.code[at].operand = int32(b.labels[label])
+	}
+	c.module.functions[index] = *b.function
+}
+
+func (c *bytecodeCompiler) statement(node AST, env *Environment, b *bytecodeBuilder) {
+	switch node := node.(type) {
+	case Return:
+		c.expression(node.term, env, b)
+		b.emit(opReturn, 0)
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			c.statement(statement, scope, b)
+		}
+	case If:
+		ifFalse := NewLabel()
+		end := NewLabel()
+		c.expression(node.conditional, env, b)
+		b.jump(opJumpIfZero, ifFalse)
+		c.statement(node.consequence, env.scope(), b)
+		b.jump(opJump, end)
+		b.place(ifFalse)
+		if node.alternative != nil {
+			c.statement(node.alternative, env.scope(), b)
+		}
+		b.place(end)
+	case While:
+		loopStart := NewLabel()
+		loopEnd := NewLabel()
+		b.place(loopStart)
+		c.expression(node.conditional, env, b)
+		b.jump(opJumpIfZero, loopEnd)
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStart})
+		c.statement(node.body, body, b)
+		b.jump(opJump, loopStart)
+		b.place(loopEnd)
+	case For:
+		loopStart := NewLabel()
+		loopStep := NewLabel()
+		loopEnd := NewLabel()
+		// a variable declared in init is visible to the whole loop only
+		scope := env.scope()
+		if node.init != nil {
+			c.statement(node.init, scope, b)
+		}
+		b.place(loopStart)
+		if node.conditional != nil {
+			c.expression(node.conditional, scope, b)
+			b.jump(opJumpIfZero, loopEnd)
+		}
+		body := scope.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStep})
+		c.statement(node.body, body, b)
+		b.place(loopStep)
+		if node.step != nil {
+			c.statement(node.step, scope, b)
+		}
+		b.jump(opJump, loopStart)
+		b.place(loopEnd)
+	case Switch:
+		plan := node.plan()
+		value := b.local()
+		c.expression(node.value, env, b)
+		b.emit(opStore, int32(value))
+		for i, caseValue := range plan.values {
+			b.emit(opLoad, int32(value))
+			b.emit(opConstant, int32(caseValue))
+			b.emit(opNotEqual, 0)
+			b.jump(opJumpIfZero, plan.caseLabels[i])
+		}
+		b.jump(opJump, plan.target)
+		scope := plan.scope(env)
+		for i, clause := range node.cases {
+			b.place(plan.labels[i])
+			for _, statement := range clause.statements {
+				c.statement(statement, scope, b)
+			}
+		}
+		b.place(plan.end)
+	case Break:
+		loop, ok := env.innermostLoop()
+		if !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		b.jump(opJump, loop.breakLabel)
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		b.jump(opJump, loop.continueLabel)
+	case Assign:
+		c.assign(node.name, node.value, env, b)
+	case MemberAssign:
+		c.expression(node.object, env, b)
+		offset := c.field(node.field, node.pos, env, b)
+		c.expression(node.value, env, b)
+		b.emit(opStoreHeap, offset)
+	case Var:
+		if _, exists := env.locals[node.name]; exists {
+			panic(fmt.Sprintf("Variable already declared in this scope: %s", node.name))
+		}
+		if isRecursive(node) {
+			b.emit(opConstant, 0)
+			c.declare(node.name, env, b)
+			c.assign(node.name, node.value, env, b)
+			return
+		}
+		c.expression(node.value, env, b)
+		c.declare(node.name, env, b)
+	case Assert:
+		failed := NewLabel()
+		end := NewLabel()
+		c.expression(node.condition, env, b)
+		b.emit(opConstant, 1)
+		b.emit(opEqual, 0)
+		b.jump(opJumpIfZero, failed)
+		b.emit(opConstant, '.')
+		b.jump(opJump, end)
+		b.place(failed)
+		b.emit(opConstant, 'F')
+		b.place(end)
+		b.emit(opCallExtern, int32(c.externs["putchar"]))
+		b.emit(opPop, 0)
+	case Function, Extern, Import, Export:
+		panic(misplaced(node))
+	default:
+		c.expression(node, env, b)
+		b.emit(opPop, 0)
+	}
+}
+
+// declare binds a variable to a new local and pops its value into it.
+func (c *bytecodeCompiler) declare(name string, env *Environment, b *bytecodeBuilder) {
+	if env.boxed[name] {
+		b.emit(opBox, 0)
+	}
+	env.locals[name] = b.local()
+	b.emit(opStore, int32(env.locals[name]))
+}
+
+// assign stores value into name. The address of a cell goes on the stack
+// before the value.
+func (c *bytecodeCompiler) assign(name string, value AST, env *Environment, b *bytecodeBuilder) {
+	if index, exists := env.lookup(name); exists {
+		if !env.boxed[name] {
+			c.expression(value, env, b)
+			b.emit(opStore, int32(index))
+			return
+		}
+		b.emit(opLoad, int32(index))
+	} else if _, exists := env.captures[name]; exists {
+		c.cell(name, env, b)
+	} else if global, exists := c.globals[name]; exists {
+		c.expression(value, env, b)
+		b.emit(opStoreGlobal, int32(global))
+		return
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+	c.expression(value, env, b)
+	b.emit(opStoreHeap, 0)
+}
+
+// cell pushes the address of the heap cell of a boxed or captured
+// variable.
+func (c *bytecodeCompiler) cell(name string, env *Environment, b *bytecodeBuilder) {
+	if index, exists := env.lookup(name); exists {
+		b.emit(opLoad, int32(index))
+		return
+	}
+	environment, _ := env.lookup(closureParameter)
+	b.emit(opLoad, int32(environment))
+	b.emit(opLoadHeap, int32(env.captures[name]))
+}
+
+func (c *bytecodeCompiler) variable(name string, env *Environment, b *bytecodeBuilder) {
+	if index, exists := env.lookup(name); exists {
+		b.emit(opLoad, int32(index))
+		if env.boxed[name] {
+			b.emit(opLoadHeap, 0)
+		}
+	} else if _, exists := env.captures[name]; exists {
+		c.cell(name, env, b)
+		b.emit(opLoadHeap, 0)
+	} else if global, exists := c.globals[name]; exists {
+		b.emit(opLoadGlobal, int32(global))
+	} else if env.symbols.functions[name] {
+		b.emit(opConstant, c.staticClosure(name))
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+// field turns the record on the stack into the base of one of its fields
+// and returns the field's offset from that base.
+func (c *bytecodeCompiler) field(name string, pos Position, env *Environment, b *bytecodeBuilder) int32 {
+	if index, known := env.symbols.fields[pos]; known {
+		return int32(index + 1)
+	}
+	b.emit(opField, int32(env.symbols.fieldId(name)))
+	return 0
+}
+
+// bytecodeOperators are the instructions of the binary operators.
+var bytecodeOperators = map[string]opcode{
+	"Equal":    opEqual,
+	"NotEqual": opNotEqual,
+	"Add":      opAdd,
+	"Subtract": opSubtract,
+	"Multiply": opMultiply,
+	"Divide":   opDivide,
+	"Modulo":   opRemainder,
+}
+
+func (c *bytecodeCompiler) binary(left, right AST, operator string, env *Environment, b *bytecodeBuilder) {
+	c.expression(left, env, b)
+	c.expression(right, env, b)
+	b.emit(bytecodeOperators[operator], 0)
+}
+
+func (c *bytecodeCompiler) expression(node AST, env *Environment, b *bytecodeBuilder) {
+	switch node := node.(type) {
+	case Number:
+		b.emit(opConstant, int32(node.value))
+	case Boolean:
+		if node.value {
+			b.emit(opConstant, 1)
+		} else {
+			b.emit(opConstant, 0)
+		}
+	case Id:
+		c.variable(node.value, env, b)
+	case Not:
+		c.expression(node.term, env, b)
+		b.emit(opNot, 0)
+	case Negate:
+		c.expression(node.term, env, b)
+		b.emit(opNegate, 0)
+	case Equal:
+		c.binary(node.left, node.right, "Equal", env, b)
+	case NotEqual:
+		c.binary(node.left, node.right, "NotEqual", env, b)
+	case Add:
+		c.binary(node.left, node.right, "Add", env, b)
+	case Subtract:
+		c.binary(node.left, node.right, "Subtract", env, b)
+	case Multiply:
+		c.binary(node.left, node.right, "Multiply", env, b)
+	case Divide:
+		c.binary(node.left, node.right, "Divide", env, b)
+	case Modulo:
+		c.binary(node.left, node.right, "Modulo", env, b)
+	case Call:
+		for _, arg := range node.args {
+			c.expression(arg, env, b)
+		}
+		if env.isVariable(node.callee) {
+			c.variable(node.callee, env, b)
+			b.emit(opCallClosure, int32(len(node.args)))
+		} else if function, exists := c.functions[node.callee]; exists {
+			b.emit(opCall, int32(function))
+		} else if extern, exists := c.externs[node.callee]; exists {
+			b.emit(opCallExtern, int32(extern))
+		} else {
+			panic(fmt.Sprintf("Undefined function: %s", node.callee))
+		}
+	case FunctionExpression:
+		c.closure(node, env, b)
+	case Record:
+		names := layout(node.names())
+		for i := 1; i < len(names); i++ {
+			if names[i] == names[i-1] {
+				panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+			}
+		}
+		b.emit(opAlloc, int32(len(names)+1))
+		b.emit(opDuplicate, 0)
+		b.emit(opConstant, c.shape(names, env))
+		b.emit(opStoreHeap, 0)
+		for _, field := range node.fields {
+			b.emit(opDuplicate, 0)
+			c.expression(field.value, env, b)
+			b.emit(opStoreHeap, int32(slices.Index(names, field.name)+1))
+		}
+	case Member:
+		c.expression(node.object, env, b)
+		b.emit(opLoadHeap, c.field(node.field, node.pos, env, b))
+	default:
+		panic(fmt.Sprintf("%T cannot be used as a value", node))
+	}
+}
+
+// closure compiles a function expression into a function of its own and
+// creates the closure, whose environment is an array of the cells of the
+// captured variables.
+func (c *bytecodeCompiler) closure(fe FunctionExpression, env *Environment, b *bytecodeBuilder) {
+	code := NewLabel()
+	captures := fe.captures(env)
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	index := len(c.module.functions)
+	c.module.functions = append(c.module.functions, bytecodeFunction{})
+	c.function(index, fe.function(code), env, indexes)
+
+	if len(captures) == 0 {
+		b.emit(opConstant, 0)
+	} else {
+		b.emit(opAlloc, int32(len(captures)))
+		for i, name := range captures {
+			b.emit(opDuplicate, 0)
+			c.cell(name, env, b)
+			b.emit(opStoreHeap, int32(i))
+		}
+	}
+	b.emit(opClosure, int32(index))
+}
+
+// MarshalBinary encodes the module as the contents of a .bbc file: the
+// magic and version, then the externs, functions, globals, initial heap
+// and main. Numbers are varints and strings are length-prefixed.
+func (m *bytecodeModule) MarshalBinary() ([]byte, error) {
+	data := []byte(bytecodeMagic)
+	data = binary.LittleEndian.AppendUint16(data, bytecodeVersion)
+	appendString := func(s string) {
+		data = binary.AppendUvarint(data, uint64(len(s)))
+		data = append(data, s...)
+	}
+	data = binary.AppendUvarint(data, uint64(len(m.externs)))
+	for _, extern := range m.externs {
+		appendString(extern.name)
+		data = binary.AppendUvarint(data, uint64(extern.parameters))
+	}
+	data = binary.AppendUvarint(data, uint64(len(m.functions)))
+	for _, function := range m.functions {
+		appendString(function.name)
+		data = binary.AppendUvarint(data, uint64(function.parameters))
+		data = binary.AppendUvarint(data, uint64(function.locals))
+		data = binary.AppendUvarint(data, uint64(len(function.code)))
+		for _, instruction := range function.code {
+			data = append(data, byte(instruction.op))
+			if instruction.op.hasOperand() {
+				data = binary.AppendVarint(data, int64(instruction.operand))
+			}
+		}
+	}
+	for _, words := range [][]int32{m.globals, m.heap} {
+		data = binary.AppendUvarint(data, uint64(len(words)))
+		for _, word := range words {
+			data = binary.AppendVarint(data, int64(word))
+		}
+	}
+	data = binary.AppendVarint(data, int64(m.main))
+	return data, nil
+}
+
+// UnmarshalBinary decodes the contents of a .bbc file, checking that
+// every operand refers to something that exists, so that the virtual
+// machine need not.
+func (m *bytecodeModule) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(bytecodeMagic)) || len(data) < len(bytecodeMagic)+2 {
+		return errors.New("not a bytecode file")
+	}
+	if version := binary.LittleEndian.Uint16(data[len(bytecodeMagic):]); version != bytecodeVersion {
+		return fmt.Errorf("bytecode version %d is not supported, expected %d", version, bytecodeVersion)
+	}
+	r := bytes.NewReader(data[len(bytecodeMagic)+2:])
+	var err error
+	count := func(limit int) int {
+		n, e := binary.ReadUvarint(r)
+		if err == nil && e != nil {
+			err = e
+		}
+		if err == nil && n > uint64(limit) {
+			err = fmt.Errorf("count %d out of range", n)
+		}
+		if err != nil {
+			return 0
+		}
+		return int(n)
+	}
+	word := func() int32 {
+		n, e := binary.ReadVarint(r)
+		if err == nil && e != nil {
+			err = e
+		}
+		if err == nil && int64(int32(n)) != n {
+			err = fmt.Errorf("word %d out of range", n)
+		}
+		return int32(n)
+	}
+	readString := func() string {
+		s := make([]byte, count(r.Len()))
+		if _, e := io.ReadFull(r, s); err == nil && e != nil {
+			err = e
+		}
+		return string(s)
+	}
+
+	*m = bytecodeModule{}
+	for range count(r.Len()) {
+		m.externs = append(m.externs, bytecodeExtern{name: readString(), parameters: count(r.Len())})
+	}
+	for range count(r.Len()) {
+		function := bytecodeFunction{name: readString(), parameters: count(r.Len()), locals: count(r.Len())}
+		for range count(r.Len()) {
+			op, e := r.ReadByte()
+			if e != nil {
+				return e
+			}
+			instruction := instruction{op: opcode(op)}
+			if instruction.op >= opcodeCount {
+				return fmt.Errorf("unknown opcode %d in %s", op, function.name)
+			}
+			if instruction.op.hasOperand() {
+				instruction.operand = word()
+			}
+			function.code = append(function.code, instruction)
+		}
+		m.functions = append(m.functions, function)
+	}
+	for _, words := range []*[]int32{&m.globals, &m.heap} {
+		for range count(r.Len()) {
+			*words = append(*words, word())
+		}
+	}
+	m.main = int(word())
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return errors.New("trailing data after the bytecode")
+	}
+	return m.verify()
+}
+
+// verify checks the operands of every instruction, and that no function
+// can run off its end.
+func (m *bytecodeModule) verify() error {
+	if m.main < -1 || m.main >= len(m.functions) {
+		return fmt.Errorf("main function %d out of range", m.main)
+	}
+	for _, function := range m.functions {
+		if function.parameters > function.locals {
+			return fmt.Errorf("%s has more parameters than locals", function.name)
+		}
+		if len(function.code) == 0 || function.code[len(function.code)-1].op != opReturn {
+			return fmt.Errorf("%s does not end with a return", function.name)
+		}
+		for at, instruction := range function.code {
+			limit := -1
+			switch instruction.op {
+			case opLoad, opStore:
+				limit = function.locals
+			case opLoadGlobal, opStoreGlobal:
+				limit = len(m.globals)
+			case opJump, opJumpIfZero:
+				limit = len(function.code)
+			case opCall, opClosure:
+				limit = len(m.functions)
+			case opCallExtern:
+				limit = len(m.externs)
+			case opAlloc, opLoadHeap, opStoreHeap, opCallClosure:
+				limit = math.MaxInt
+			}
+			if limit >= 0 && (instruction.operand < 0 || int(instruction.operand) >= limit) {
+				return fmt.Errorf("%s: instruction %d: operand %d out of range", function.name, at, instruction.operand)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,845 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// cGenerator emits a C99 translation unit. Every value is an int32_t and
+// every function returns one, 0 when it ends without a return. Variables
+// are locals declared at the top of their function, except that captured
+// variables hold the address of a heap cell as on ARM, and top-level
+// variables are static globals.
+//
+// Records, closures and cells live in an array of words, baseline_heap,
+// and are referred to by index, so that they fit in an int32_t on any
+// host. They are laid out as on ARM, with an index into
+// baseline_functions in place of the code address. Top-level functions
+// used as values go through an adapter that drops the environment. The
+// heap is never reclaimed.
+//
+// Arithmetic wraps around, and division by zero gives 0, as on ARM. C
+// leaves the order in which operands are evaluated unspecified, so when
+// it matters they are evaluated in order into temporaries first.
+type cGenerator struct {
+	functions   [][]string
+	prototypes  []string
+	table       []string
+	arities     map[string]int
+	callTypes   map[int]bool
+	externs     []string
+	data        []int
+	closures    map[string]int
+	shapes      map[string]int
+	reserved    []string
+	environment string
+}
+
+// cFunction is a function being generated. locals are the C names of its
+// parameters and locals, which are unique within the function and do not
+// hide any top-level name.
+type cFunction struct {
+	locals []string
+	code   []string
+	depth  int
+	// steps are the continue labels of the loops whose step is a
+	// statement, which a continue reaches with a goto, and gotos those
+	// that a continue used
+	steps, gotos []*Label
+}
+
+func (f *cFunction) emit(format string, args ...any) {
+	f.code = append(f.code, strings.Repeat("    ", f.depth+1)+fmt.Sprintf(format, args...))
+}
+
+// cKeywords are the keywords of C99 and the names the generated code
+// itself declares, which identifiers must not take.
+var cKeywords = []string{
+	"auto", "break", "case", "char", "const", "continue", "default", "do",
+	"double", "else", "enum", "extern", "float", "for", "goto", "if",
+	"inline", "int", "long", "register", "restrict", "return", "short",
+	"signed", "sizeof", "static", "struct", "switch", "typedef", "union",
+	"unsigned", "void", "volatile", "while", "_Bool", "_Complex",
+	"_Imaginary", "main", "abort", "realloc", "int32_t", "uint32_t",
+	"size_t", "NULL",
+}
+
+// cName returns the C identifier of a baseline identifier. Reserved names
+// and names that end with an underscore get one appended, which keeps the
+// mapping one-to-one. Names of imported modules and of the compiler's own
+// variables contain dots and dollar signs, which C does not allow; they
+// are spelled with _d and _s after a prefix no other name maps to, and _u
+// stands for an underscore.
+func cName(name string) string {
+	if strings.ContainsAny(name, ".$") {
+		return "baseline_name_" + strings.NewReplacer("_", "_u", ".", "_d", "$", "_s").Replace(name)
+	}
+	if slices.Contains(cKeywords, name) || strings.HasPrefix(name, "baseline_") || strings.HasPrefix(name, "_") || strings.HasSuffix(name, "_") {
+		return name + "_"
+	}
+	return name
+}
+
+// local adds a local named after name and returns its C name.
+func (c *cGenerator) local(f *cFunction, name string) string {
+	if name == closureParameter {
+		f.locals = append(f.locals, c.environment)
+		return c.environment
+	}
+	base := cName(name)
+	unique := base
+	for i := 1; slices.Contains(f.locals, unique) || slices.Contains(c.reserved, unique); i++ {
+		unique = fmt.Sprintf("%s_%d", base, i)
+	}
+	f.locals = append(f.locals, unique)
+	return unique
+}
+
+func (c *cGenerator) temporary(f *cFunction) string {
+	return c.local(f, "temporary")
+}
+
+// word allocates words in the initial heap and returns their address.
+func (c *cGenerator) word(words ...int) int {
+	address := len(c.data)
+	c.data = append(c.data, words...)
+	return address
+}
+
+func (c *cGenerator) shape(names []string, env *Environment) int {
+	names = layout(names)
+	key := strings.Join(names, ",")
+	if address, exists := c.shapes[key]; exists {
+		return address
+	}
+	words := []int{len(names)}
+	for _, name := range names {
+		words = append(words, env.symbols.fieldId(name))
+	}
+	c.shapes[key] = c.word(words...)
+	return c.shapes[key]
+}
+
+func cParameters(names []string) string {
+	if len(names) == 0 {
+		return "void"
+	}
+	parameters := []string{}
+	for _, name := range names {
+		parameters = append(parameters, "int32_t "+name)
+	}
+	return strings.Join(parameters, ", ")
+}
+
+// staticClosure returns the address of the closure of a top-level or
+// external function, whose adapter ignores the environment.
+func (c *cGenerator) staticClosure(name string) int {
+	if address, exists := c.closures[name]; exists {
+		return address
+	}
+	adapter := "baseline_closure_" + cName(name)
+	parameters := []string{}
+	for i := 0; i < c.arities[name]; i++ {
+		parameters = append(parameters, fmt.Sprintf("a%d", i))
+	}
+	header := fmt.Sprintf("static int32_t %s(%s)", adapter, cParameters(append(slices.Clone(parameters), c.environment)))
+	c.prototypes = append(c.prototypes, header+";")
+	c.functions = append(c.functions, []string{
+		header + " {",
+		fmt.Sprintf("    (void)%s;", c.environment),
+		fmt.Sprintf("    return %s(%s);", c.functionName(name), strings.Join(parameters, ", ")),
+		"}",
+	})
+	c.table = append(c.table, adapter)
+	c.closures[name] = c.word(len(c.table)-1, 0)
+	return c.closures[name]
+}
+
+// functionName returns the C name of a function; externs keep theirs.
+func (c *cGenerator) functionName(name string) string {
+	if slices.Contains(c.externs, name) {
+		return name
+	}
+	return cName(name)
+}
+
+// emit generates the translation unit of a program.
+func (c *cGenerator) emit(node AST, env *Environment) {
+	program, ok := node.(Program)
+	if !ok {
+		panic(fmt.Sprintf("cannot compile %T outside of a program", node))
+	}
+	c.emitProgram(program, env)
+}
+
+func (c *cGenerator) emitProgram(p Program, env *Environment) {
+	c.arities = make(map[string]int)
+	c.callTypes = make(map[int]bool)
+	c.closures = make(map[string]int)
+	c.shapes = make(map[string]int)
+	c.environment = "baseline_environment"
+	// address 0 stays unused so that no object is null
+	c.data = []int{0}
+
+	parts := p.split(env)
+	c.externs = parts.externs
+	c.reserved = []string{c.environment}
+	for _, statement := range p.statements {
+		switch statement := statement.(type) {
+		case Function:
+			c.arities[statement.name] = len(statement.parameters)
+			c.reserved = append(c.reserved, cName(statement.name))
+		case Extern:
+			if _, exists := c.arities[statement.name]; !exists {
+				c.arities[statement.name] = len(statement.parameters)
+				c.reserved = append(c.reserved, statement.name)
+			}
+		}
+	}
+	for _, global := range parts.initialized {
+		c.reserved = append(c.reserved, cName(global.name))
+	}
+	for _, name := range parts.uninitialized {
+		c.reserved = append(c.reserved, cName(name))
+	}
+
+	c.function("baseline_init", parts.init(), env, nil)
+	hasMain := false
+	for _, function := range parts.functions {
+		switch function := function.(type) {
+		case Function:
+			hasMain = hasMain || function.name == "main"
+			c.function(cName(function.name), function, env, nil)
+		case Main:
+			hasMain = true
+			c.function(cName("main"), Function{name: "main", parameters: []string{}, body: Block{statements: function.statements}}, env, nil)
+		}
+	}
+
+	emit("#include <stdint.h>")
+	emit("#include <stddef.h>")
+	emit("")
+	emit("void abort(void);")
+	emit("void *realloc(void *, size_t);")
+	if !slices.Contains(parts.externs, "putchar") {
+		// assert prints through putchar
+		emit("int putchar(int);")
+	}
+	for _, name := range parts.externs {
+		parameters := slices.Repeat([]string{"int32_t"}, c.arities[name])
+		if len(parameters) == 0 {
+			parameters = []string{"void"}
+		}
+		emit(fmt.Sprintf("int32_t %s(%s);", name, strings.Join(parameters, ", ")))
+	}
+	emit("")
+	emit("typedef void (*baseline_function)(void);")
+	for _, arity := range slices.Sorted(func(yield func(int) bool) {
+		for arity := range c.callTypes {
+			if !yield(arity) {
+				return
+			}
+		}
+	}) {
+		emit(fmt.Sprintf("typedef int32_t (*baseline_closure%d)(%s);", arity, strings.Join(slices.Repeat([]string{"int32_t"}, arity), ", ")))
+	}
+	emit("")
+	for _, prototype := range c.prototypes {
+		emit(prototype)
+	}
+	emit("")
+	for _, global := range parts.initialized {
+		emit(fmt.Sprintf("static int32_t %s = %s;", cName(global.name), cNumber(global.value.(Number).value)))
+	}
+	for _, name := range parts.uninitialized {
+		emit(fmt.Sprintf("static int32_t %s;", cName(name)))
+	}
+	emit("")
+	words := []string{}
+	for _, word := range c.data {
+		words = append(words, cNumber(word))
+	}
+	emit(fmt.Sprintf("static const int32_t baseline_data[] = {%s};", strings.Join(words, ", ")))
+	entries := []string{}
+	for _, function := range c.table {
+		entries = append(entries, "(baseline_function)"+function)
+	}
+	if len(entries) == 0 {
+		entries = []string{"NULL"}
+	}
+	emit(fmt.Sprintf("static const baseline_function baseline_functions[] = {%s};", strings.Join(entries, ", ")))
+	emit(cRuntime)
+	for _, function := range c.functions {
+		emit("")
+		for _, line := range function {
+			emit(line)
+		}
+	}
+	if hasMain {
+		emit("")
+		emit("int main(void) {")
+		emit("    baseline_start();")
+		emit(fmt.Sprintf("    return %s();", cName("main")))
+		emit("}")
+	}
+}
+
+// cNumber formats a word as a C constant; the most negative one has no
+// literal of type int.
+func cNumber(value int) string {
+	if int32(value) == math.MinInt32 {
+		return "(-2147483647 - 1)"
+	}
+	return fmt.Sprintf("%d", int32(value))
+}
+
+// function generates a function named name. captures numbers the
+// variables in the environment of a closure.
+func (c *cGenerator) function(name string, function Function, outer *Environment, captures map[string]int) {
+	f := &cFunction{}
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(function.body)
+	env.captures = captures
+	parameters := []string{}
+	for i, param := range function.parameters {
+		parameters = append(parameters, c.local(f, param))
+		env.locals[param] = i
+	}
+	for _, param := range function.parameters {
+		if env.boxed[param] {
+			local := f.locals[env.locals[param]]
+			f.emit("%s = baseline_box(%s);", local, local)
+		}
+	}
+	if captures != nil && len(captures) == 0 {
+		f.emit("(void)%s;", c.environment)
+	}
+	if function.name == "main" {
+		f.emit("baseline_init();")
+	}
+	c.statement(function.body, env, f)
+	if len(f.code) == 0 || !strings.HasPrefix(f.code[len(f.code)-1], "    return ") {
+		f.emit("return 0;")
+	}
+
+	header := fmt.Sprintf("static int32_t %s(%s)", name, cParameters(parameters))
+	c.prototypes = append(c.prototypes, header+";")
+	lines := []string{header + " {"}
+	if len(f.locals) > len(parameters) {
+		lines = append(lines, fmt.Sprintf("    int32_t %s;", strings.Join(f.locals[len(parameters):], ", ")))
+	}
+	lines = append(lines, f.code...)
+	lines = append(lines, "}")
+	c.functions = append(c.functions, lines)
+}
+
+func (c *cGenerator) statement(node AST, env *Environment, f *cFunction) {
+	switch node := node.(type) {
+	case Return:
+		f.emit("return %s;", c.expression(node.term, env, f))
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			c.statement(statement, scope, f)
+		}
+	case If:
+		f.emit("if (%s) {", c.condition(node.conditional, env, f))
+		for {
+			f.depth++
+			c.statement(node.consequence, env.scope(), f)
+			f.depth--
+			alternative, elseIf := node.alternative.(If)
+			if !elseIf {
+				break
+			}
+			node = alternative
+			f.emit("} else if (%s) {", c.condition(node.conditional, env, f))
+		}
+		if node.alternative != nil {
+			f.emit("} else {")
+			f.depth++
+			c.statement(node.alternative, env.scope(), f)
+			f.depth--
+		}
+		f.emit("}")
+	case While:
+		f.emit("while (%s) {", c.condition(node.conditional, env, f))
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: NewLabel(), continueLabel: NewLabel()})
+		f.depth++
+		c.statement(node.body, body, f)
+		f.depth--
+		f.emit("}")
+	case For:
+		// a variable declared in init is visible to the whole loop only
+		scope := env.scope()
+		init, conditional, step := "", "", ""
+		if node.init != nil {
+			init = c.sideEffect(node.init, scope, f)
+		}
+		if node.conditional != nil {
+			conditional = " " + c.condition(node.conditional, scope, f)
+		}
+		if node.step != nil && !cStatement(node.step) {
+			step = " " + c.sideEffect(node.step, scope, f)
+		}
+		f.emit("for (%s;%s;%s) {", init, conditional, step)
+		body := scope.scope()
+		loopStep := NewLabel()
+		body.loops = append(body.loops, loopLabels{breakLabel: NewLabel(), continueLabel: loopStep})
+		if cStatement(node.step) {
+			f.steps = append(f.steps, loopStep)
+		}
+		f.depth++
+		c.statement(node.body, body, f)
+		if cStatement(node.step) {
+			// the step runs at the end of every iteration
+			if slices.Contains(f.gotos, loopStep) {
+				f.emit("%s:;", cLabel(loopStep))
+			}
+			c.statement(node.step, scope.scope(), f)
+		}
+		f.depth--
+		f.emit("}")
+	case Switch:
+		plan := node.plan()
+		f.emit("switch (%s) {", c.expression(node.value, env, f))
+		scope := plan.scope(env)
+		for _, clause := range node.cases {
+			if clause.isDefault {
+				f.emit("default:")
+			} else {
+				f.emit("case %s:", cNumber(clause.value))
+			}
+			f.depth++
+			for _, statement := range clause.statements {
+				c.statement(statement, scope, f)
+			}
+			f.depth--
+		}
+		if len(node.cases) > 0 && len(node.cases[len(node.cases)-1].statements) == 0 {
+			// a label must precede a statement
+			f.depth++
+			f.emit("break;")
+			f.depth--
+		}
+		f.emit("}")
+	case Break:
+		if _, ok := env.innermostLoop(); !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		f.emit("break;")
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		if slices.Contains(f.steps, loop.continueLabel) {
+			f.gotos = append(f.gotos, loop.continueLabel)
+			f.emit("goto %s;", cLabel(loop.continueLabel))
+			return
+		}
+		f.emit("continue;")
+	case Assert:
+		f.emit("baseline_assert(%s);", c.expression(node.condition, env, f))
+	case Assign, MemberAssign, Var:
+		f.emit("%s;", c.sideEffect(node, env, f))
+	case Function, Extern, Import, Export:
+		panic(misplaced(node))
+	default:
+		if _, call := node.(Call); call {
+			f.emit("%s;", c.expression(node, env, f))
+		} else {
+			f.emit("(void)%s;", c.expression(node, env, f))
+		}
+	}
+}
+
+// condition returns the C expression of the condition of a statement,
+// without the parentheses of a comparison.
+func (c *cGenerator) condition(node AST, env *Environment, f *cFunction) string {
+	condition := c.expression(node, env, f)
+	switch node.(type) {
+	case Equal, NotEqual:
+		return condition[1 : len(condition)-1]
+	}
+	return condition
+}
+
+// cStatement reports whether node has no C expression, so that it cannot
+// be a clause of a for loop.
+func cStatement(node AST) bool {
+	switch node.(type) {
+	case Return, Block, If, While, For, Switch, Break, Continue, Assert:
+		return true
+	}
+	return false
+}
+
+// cLabel returns the C label of a label.
+func cLabel(label *Label) string {
+	return fmt.Sprintf("baseline_step_%d", label.value)
+}
+
+// sideEffect returns a C expression for an assignment, declaration or
+// expression statement, as in the clauses of a for loop. Any other
+// statement is emitted before the loop instead.
+func (c *cGenerator) sideEffect(node AST, env *Environment, f *cFunction) string {
+	switch node := node.(type) {
+	case Assign:
+		return c.assign(node.name, node.value, env, f)
+	case MemberAssign:
+		values, setup := c.operands([]AST{node.object, node.value}, env, f)
+		if index, known := env.symbols.fields[node.pos]; known {
+			return sequence(setup, fmt.Sprintf("baseline_store(%s + %d, %s)", values[0], index+1, values[1]))
+		}
+		return sequence(setup, fmt.Sprintf("baseline_store(baseline_field(%s, %d), %s)", values[0], env.symbols.fieldId(node.field), values[1]))
+	case Var:
+		if _, exists := env.locals[node.name]; exists {
+			panic(fmt.Sprintf("Variable already declared in this scope: %s", node.name))
+		}
+		if isRecursive(node) {
+			c.declare(node.name, env, f)
+			local := f.locals[env.locals[node.name]]
+			initial := local + " = 0"
+			if env.boxed[node.name] {
+				initial = local + " = baseline_box(0)"
+			}
+			return initial + ", " + c.assign(node.name, node.value, env, f)
+		}
+		value := c.expression(node.value, env, f)
+		local := c.declare(node.name, env, f)
+		if env.boxed[node.name] {
+			return fmt.Sprintf("%s = baseline_box(%s)", local, value)
+		}
+		return fmt.Sprintf("%s = %s", local, value)
+	case Call:
+		return c.expression(node, env, f)
+	default:
+		if cStatement(node) {
+			c.statement(node, env, f)
+			return ""
+		}
+		return "(void)" + c.expression(node, env, f)
+	}
+}
+
+// declare binds a variable to a new local and returns its C name.
+func (c *cGenerator) declare(name string, env *Environment, f *cFunction) string {
+	local := c.local(f, name)
+	env.locals[name] = len(f.locals) - 1
+	return local
+}
+
+func (c *cGenerator) assign(name string, value AST, env *Environment, f *cFunction) string {
+	if index, exists := env.lookup(name); exists {
+		local := f.locals[index]
+		if env.boxed[name] {
+			return fmt.Sprintf("baseline_store(%s, %s)", local, c.expression(value, env, f))
+		}
+		return fmt.Sprintf("%s = %s", local, c.expression(value, env, f))
+	} else if _, exists := env.captures[name]; exists {
+		return fmt.Sprintf("baseline_store(%s, %s)", c.cell(name, env, f), c.expression(value, env, f))
+	} else if env.symbols.globals[name] {
+		return fmt.Sprintf("%s = %s", cName(name), c.expression(value, env, f))
+	}
+	panic(fmt.Sprintf("Undefined variable: %s", name))
+}
+
+// cell returns the address of the heap cell of a boxed or captured
+// variable.
+func (c *cGenerator) cell(name string, env *Environment, f *cFunction) string {
+	if index, exists := env.lookup(name); exists {
+		return f.locals[index]
+	}
+	return fmt.Sprintf("baseline_load(%s + %d)", c.environment, env.captures[name])
+}
+
+func (c *cGenerator) variable(name string, env *Environment, f *cFunction) string {
+	if index, exists := env.lookup(name); exists {
+		if env.boxed[name] {
+			return fmt.Sprintf("baseline_load(%s)", f.locals[index])
+		}
+		return f.locals[index]
+	} else if _, exists := env.captures[name]; exists {
+		return fmt.Sprintf("baseline_load(%s)", c.cell(name, env, f))
+	} else if env.symbols.globals[name] {
+		return cName(name)
+	} else if env.symbols.functions[name] {
+		return fmt.Sprintf("%d", c.staticClosure(name))
+	}
+	panic(fmt.Sprintf("Undefined variable: %s", name))
+}
+
+// hasCall reports whether evaluating node may call a function, which may
+// change any variable.
+func hasCall(node AST) bool {
+	switch node.(type) {
+	case Call:
+		return true
+	case FunctionExpression:
+		return false
+	}
+	return slices.ContainsFunc(children(node), hasCall)
+}
+
+// operands returns the C expressions of nodes, to be evaluated in order.
+// When one of them calls a function, the operands up to the last that
+// does are evaluated into temporaries by the returned setup expressions.
+func (c *cGenerator) operands(nodes []AST, env *Environment, f *cFunction) ([]string, []string) {
+	last := -1
+	for i, node := range nodes {
+		if hasCall(node) {
+			last = i
+		}
+	}
+	values, setup := []string{}, []string{}
+	for i, node := range nodes {
+		value := c.expression(node, env, f)
+		if _, constant := node.(Number); i <= last && last > 0 && !constant {
+			temporary := c.temporary(f)
+			setup = append(setup, fmt.Sprintf("%s = %s", temporary, value))
+			value = temporary
+		}
+		values = append(values, value)
+	}
+	return values, setup
+}
+
+// sequence evaluates setup before expression.
+func sequence(setup []string, expression string) string {
+	if len(setup) == 0 {
+		return expression
+	}
+	return "(" + strings.Join(setup, ", ") + ", " + expression + ")"
+}
+
+// cOperators are the helpers implementing the arithmetic operators.
+var cOperators = map[string]string{
+	"Add":      "baseline_add",
+	"Subtract": "baseline_subtract",
+	"Multiply": "baseline_multiply",
+	"Divide":   "baseline_divide",
+	"Modulo":   "baseline_remainder",
+}
+
+func (c *cGenerator) binary(left, right AST, operator string, env *Environment, f *cFunction) string {
+	values, setup := c.operands([]AST{left, right}, env, f)
+	if helper, exists := cOperators[operator]; exists {
+		return sequence(setup, fmt.Sprintf("%s(%s, %s)", helper, values[0], values[1]))
+	}
+	return sequence(setup, fmt.Sprintf("(%s %s %s)", values[0], operator, values[1]))
+}
+
+var cIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (c *cGenerator) expression(node AST, env *Environment, f *cFunction) string {
+	switch node := node.(type) {
+	case Number:
+		return cNumber(node.value)
+	case Boolean:
+		if node.value {
+			return "1"
+		}
+		return "0"
+	case Id:
+		return c.variable(node.value, env, f)
+	case Not:
+		return "!" + c.expression(node.term, env, f)
+	case Negate:
+		return fmt.Sprintf("baseline_subtract(0, %s)", c.expression(node.term, env, f))
+	case Equal:
+		return c.binary(node.left, node.right, "==", env, f)
+	case NotEqual:
+		return c.binary(node.left, node.right, "!=", env, f)
+	case Add:
+		return c.binary(node.left, node.right, "Add", env, f)
+	case Subtract:
+		return c.binary(node.left, node.right, "Subtract", env, f)
+	case Multiply:
+		return c.binary(node.left, node.right, "Multiply", env, f)
+	case Divide:
+		return c.binary(node.left, node.right, "Divide", env, f)
+	case Modulo:
+		return c.binary(node.left, node.right, "Modulo", env, f)
+	case Call:
+		if !env.isVariable(node.callee) {
+			values, setup := c.operands(node.args, env, f)
+			return sequence(setup, fmt.Sprintf("%s(%s)", c.functionName(node.callee), strings.Join(values, ", ")))
+		}
+		// the closure's environment is passed after the declared arguments
+		values, setup := c.operands(append(slices.Clone(node.args), Id{value: node.callee}), env, f)
+		closure := values[len(values)-1]
+		if !cIdentifier.MatchString(closure) {
+			temporary := c.temporary(f)
+			setup = append(setup, fmt.Sprintf("%s = %s", temporary, closure))
+			closure = temporary
+		}
+		arity := len(node.args) + 1
+		c.callTypes[arity] = true
+		arguments := append(values[:len(values)-1], fmt.Sprintf("baseline_load(%s + 1)", closure))
+		return sequence(setup, fmt.Sprintf("((baseline_closure%d)baseline_functions[baseline_load(%s)])(%s)", arity, closure, strings.Join(arguments, ", ")))
+	case FunctionExpression:
+		return c.closure(node, env, f)
+	case Record:
+		names := layout(node.names())
+		for i := 1; i < len(names); i++ {
+			if names[i] == names[i-1] {
+				panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+			}
+		}
+		record := c.temporary(f)
+		steps := []string{
+			fmt.Sprintf("%s = baseline_alloc(%d)", record, len(names)+1),
+			fmt.Sprintf("baseline_store(%s, %d)", record, c.shape(names, env)),
+		}
+		for _, field := range node.fields {
+			steps = append(steps, fmt.Sprintf("baseline_store(%s + %d, %s)", record, slices.Index(names, field.name)+1, c.expression(field.value, env, f)))
+		}
+		return sequence(steps, record)
+	case Member:
+		object := c.expression(node.object, env, f)
+		if index, known := env.symbols.fields[node.pos]; known {
+			return fmt.Sprintf("baseline_load(%s + %d)", object, index+1)
+		}
+		return fmt.Sprintf("baseline_load(baseline_field(%s, %d))", object, env.symbols.fieldId(node.field))
+	default:
+		panic(fmt.Sprintf("%T cannot be used as a value", node))
+	}
+}
+
+// closure generates the code of a function expression as a function of
+// its own and allocates the closure: its index in baseline_functions
+// followed by the environment, an array of the cells of the captured
+// variables.
+func (c *cGenerator) closure(fe FunctionExpression, env *Environment, f *cFunction) string {
+	code := NewLabel()
+	captures := fe.captures(env)
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	name := fmt.Sprintf("baseline_function%d", code.value)
+	c.table = append(c.table, name)
+	index := len(c.table) - 1
+	c.function(name, fe.function(code), env, indexes)
+
+	if len(captures) == 0 {
+		return fmt.Sprintf("baseline_closure(%d, 0)", index)
+	}
+	environment := c.temporary(f)
+	steps := []string{fmt.Sprintf("%s = baseline_alloc(%d)", environment, len(captures))}
+	for i, name := range captures {
+		steps = append(steps, fmt.Sprintf("baseline_store(%s + %d, %s)", environment, i, c.cell(name, env, f)))
+	}
+	return sequence(steps, fmt.Sprintf("baseline_closure(%d, %s)", index, environment))
+}
+
+// cRuntime allocates from the end of the initial heap, doubling it as
+// needed, and provides the field lookup for records and the arithmetic.
+const cRuntime = `
+static int32_t *baseline_heap;
+static int32_t baseline_heap_size;
+static int32_t baseline_heap_top;
+
+static void baseline_start(void) {
+    int32_t i;
+    baseline_heap_top = (int32_t)(sizeof baseline_data / sizeof baseline_data[0]);
+    baseline_heap_size = 65536;
+    baseline_heap = realloc(NULL, sizeof(int32_t) * (size_t)baseline_heap_size);
+    if (baseline_heap == NULL) {
+        abort();
+    }
+    for (i = 0; i < baseline_heap_top; i++) {
+        baseline_heap[i] = baseline_data[i];
+    }
+}
+
+static int32_t baseline_alloc(int32_t words) {
+    int32_t block = baseline_heap_top;
+    while (baseline_heap_size - baseline_heap_top < words) {
+        if (baseline_heap_size > INT32_MAX / 2) {
+            abort();
+        }
+        baseline_heap_size *= 2;
+        baseline_heap = realloc(baseline_heap, sizeof(int32_t) * (size_t)baseline_heap_size);
+        if (baseline_heap == NULL) {
+            abort();
+        }
+    }
+    baseline_heap_top += words;
+    return block;
+}
+
+static inline int32_t baseline_load(int32_t address) {
+    return baseline_heap[address];
+}
+
+static inline void baseline_store(int32_t address, int32_t value) {
+    baseline_heap[address] = value;
+}
+
+static inline int32_t baseline_box(int32_t value) {
+    int32_t cell = baseline_alloc(1);
+    baseline_heap[cell] = value;
+    return cell;
+}
+
+static inline int32_t baseline_closure(int32_t function, int32_t environment) {
+    int32_t closure = baseline_alloc(2);
+    baseline_heap[closure] = function;
+    baseline_heap[closure + 1] = environment;
+    return closure;
+}
+
+static inline int32_t baseline_field(int32_t record, int32_t id) {
+    int32_t shape = baseline_heap[record];
+    int32_t i;
+    for (i = 1; i <= baseline_heap[shape]; i++) {
+        if (baseline_heap[shape + i] == id) {
+            return record + i;
+        }
+    }
+    abort();
+    return 0;
+}
+
+static inline int32_t baseline_add(int32_t a, int32_t b) {
+    return (int32_t)((uint32_t)a + (uint32_t)b);
+}
+
+static inline int32_t baseline_subtract(int32_t a, int32_t b) {
+    return (int32_t)((uint32_t)a - (uint32_t)b);
+}
+
+static inline int32_t baseline_multiply(int32_t a, int32_t b) {
+    return (int32_t)((uint32_t)a * (uint32_t)b);
+}
+
+static inline int32_t baseline_divide(int32_t a, int32_t b) {
+    if (b == 0) {
+        return 0;
+    }
+    if (b == -1) {
+        return baseline_subtract(0, a);
+    }
+    return a / b;
+}
+
+static inline int32_t baseline_remainder(int32_t a, int32_t b) {
+    if (b == 0) {
+        return a;
+    }
+    if (b == -1) {
+        return 0;
+    }
+    return a % b;
+}
+
+static inline void baseline_assert(int32_t condition) {
+    putchar(condition == 1 ? '.' : 'F');
+}`
@@ -0,0 +1,1017 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Type is a static type. Annotations are optional: every declaration
+// without one gets a TypeVariable, which unification later binds.
+type Type interface {
+	String() string
+}
+
+type NumberType struct{}
+
+func (NumberType) String() string { return "number" }
+
+type BooleanType struct{}
+
+func (BooleanType) String() string { return "boolean" }
+
+type VoidType struct{}
+
+func (VoidType) String() string { return "void" }
+
+type ArrayType struct {
+	element Type
+}
+
+func (a ArrayType) String() string {
+	return fmt.Sprintf("Array<%s>", typeString(a.element))
+}
+
+// FunctionType has nil parameter or result types in the signature of a
+// function that is not fully annotated.
+type FunctionType struct {
+	parameters []Type
+	result     Type
+}
+
+func (f FunctionType) String() string {
+	parameters := []string{}
+	for _, param := range f.parameters {
+		parameters = append(parameters, typeString(param))
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(parameters, ", "), typeString(f.result))
+}
+
+// RecordType lists the fields of a record sorted by name, which is also
+// the order of their layout.
+type RecordType struct {
+	fields []RecordField
+}
+
+type RecordField struct {
+	name string
+	typ  Type
+}
+
+func NewRecordType(fields []RecordField) RecordType {
+	return RecordType{fields: slices.SortedFunc(slices.Values(fields), func(a, b RecordField) int {
+		return strings.Compare(a.name, b.name)
+	})}
+}
+
+func (r RecordType) String() string {
+	return formatFields(r.fields, "", typeString)
+}
+
+func (r RecordType) field(name string) (int, bool) {
+	index := slices.IndexFunc(r.fields, func(field RecordField) bool { return field.name == name })
+	return index, index >= 0
+}
+
+func formatFields(fields []RecordField, rest string, format func(Type) string) string {
+	formatted := []string{}
+	for _, field := range fields {
+		formatted = append(formatted, fmt.Sprintf("%s: %s", field.name, format(field.typ)))
+	}
+	if rest != "" {
+		formatted = append(formatted, rest)
+	}
+	return fmt.Sprintf("{%s}", strings.Join(formatted, ", "))
+}
+
+// TypeVariable stands for a type that is not known yet. Once bound, it
+// remembers where its instance was inferred so that conflicts can point
+// at both sides. Field accesses on an unknown type constrain it to a
+// record with at least those fields.
+type TypeVariable struct {
+	id       int
+	instance Type
+	boundAt  Position
+	fields   []RecordField
+}
+
+func (v *TypeVariable) String() string {
+	if v.instance != nil {
+		return v.instance.String()
+	}
+	if len(v.fields) > 0 {
+		return formatFields(v.fields, "...", typeString)
+	}
+	return fmt.Sprintf("T%d", v.id)
+}
+
+func typeString(t Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	return t.String()
+}
+
+// resolve follows bound type variables. It also returns where the result
+// was inferred, when that is known.
+func resolve(t Type) (Type, Position, bool) {
+	var pos Position
+	located := false
+	for {
+		v, ok := t.(*TypeVariable)
+		if !ok || v.instance == nil {
+			return t, pos, located
+		}
+		t, pos, located = v.instance, v.boundAt, true
+	}
+}
+
+func prune(t Type) Type {
+	t, _, _ = resolve(t)
+	return t
+}
+
+// Scheme is a type generalized over variables, such as (T1) => T1 for an
+// identity function. Each use instantiates the variables afresh.
+type Scheme struct {
+	variables []*TypeVariable
+	typ       Type
+}
+
+func monomorphic(t Type) Scheme {
+	return Scheme{typ: t}
+}
+
+// Typing is what inference learns about a program: the signatures of its
+// top-level declarations and, for the fields accessed in records whose
+// type is known, their index in the record's layout, keyed by the position
+// of the access.
+type Typing struct {
+	signatures []string
+	fields     map[Position]int
+}
+
+// CheckTypes infers the types of a program with Hindley-Milner
+// inference, using the annotations as constraints. It returns what it
+// inferred and every conflict, located where it was detected and where
+// the other side was inferred.
+func CheckTypes(program AST) (Typing, []error) {
+	c := &checker{
+		functions: make(map[string]Scheme),
+		scope:     &typeScope{names: make(map[string]Scheme)},
+		assigned:  make(map[string]bool),
+	}
+	c.collectAssigned(program)
+	c.check(program)
+
+	typing := Typing{signatures: c.signatures, fields: make(map[Position]int)}
+	for _, access := range c.accesses {
+		if record, ok := prune(access.object).(RecordType); ok {
+			if index, exists := record.field(access.field); exists {
+				typing.fields[access.pos] = index
+			}
+		}
+	}
+	return typing, c.errors
+}
+
+// fieldAccess is a field read or write whose layout is decided once the
+// whole program has been inferred.
+type fieldAccess struct {
+	object Type
+	field  string
+	pos    Position
+}
+
+type checker struct {
+	errors     []error
+	signatures []string
+	accesses   []fieldAccess
+	functions  map[string]Scheme
+	scope      *typeScope
+	nextId     int
+	// result is the result type of the function being checked
+	result Type
+	// pos is the position of the innermost enclosing node that has one
+	pos Position
+	// loops counts the loops around the statement being checked within
+	// its function, and breakable the loops and switches
+	loops, breakable int
+	// assigned holds every name the program assigns to anywhere
+	assigned map[string]bool
+}
+
+type typeScope struct {
+	names  map[string]Scheme
+	parent *typeScope
+}
+
+func (s *typeScope) lookup(name string) (Scheme, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if scheme, exists := scope.names[name]; exists {
+			return scheme, true
+		}
+	}
+	return Scheme{}, false
+}
+
+func (c *checker) enterScope() {
+	c.scope = &typeScope{names: make(map[string]Scheme), parent: c.scope}
+}
+
+func (c *checker) exitScope() {
+	c.scope = c.scope.parent
+}
+
+func (c *checker) fresh() *TypeVariable {
+	c.nextId++
+	return &TypeVariable{id: c.nextId}
+}
+
+// located wraps t in a variable bound at the current position, so that a
+// conflict involving it can say where it came from.
+func (c *checker) located(t Type) Type {
+	return &TypeVariable{instance: t, boundAt: c.pos}
+}
+
+// annotation returns the declared type, or a fresh variable for nil.
+func (c *checker) annotation(t Type) Type {
+	if t == nil {
+		return c.fresh()
+	}
+	return c.located(t)
+}
+
+func (c *checker) errorf(format string, args ...any) {
+	c.errors = append(c.errors, fmt.Errorf("%s: %s", c.pos, fmt.Sprintf(format, args...)))
+}
+
+// unify makes a and b the same type, binding variables as needed, and
+// reports a conflict otherwise.
+func (c *checker) unify(a, b Type) {
+	if !c.unifies(a, b) {
+		c.conflict(a, b)
+	}
+}
+
+func (c *checker) conflict(a, b Type) {
+	for _, pair := range [][2]Type{{prune(a), prune(b)}, {prune(b), prune(a)}} {
+		if v, ok := pair[0].(*TypeVariable); ok && occurs(v, pair[1]) {
+			c.errorf("infinite type: %s occurs in %s", v, pair[1])
+			return
+		}
+	}
+	c.mismatch(a, b)
+}
+
+func (c *checker) unifies(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if v, ok := a.(*TypeVariable); ok {
+		return c.bind(v, b)
+	}
+	if v, ok := b.(*TypeVariable); ok {
+		return c.bind(v, a)
+	}
+	switch a := a.(type) {
+	case ArrayType:
+		b, ok := b.(ArrayType)
+		return ok && c.unifies(a.element, b.element)
+	case RecordType:
+		b, ok := b.(RecordType)
+		if !ok || len(a.fields) != len(b.fields) {
+			return false
+		}
+		unified := true
+		for i, field := range a.fields {
+			if field.name != b.fields[i].name {
+				return false
+			}
+			unified = c.unifies(field.typ, b.fields[i].typ) && unified
+		}
+		return unified
+	case FunctionType:
+		b, ok := b.(FunctionType)
+		if !ok || len(a.parameters) != len(b.parameters) {
+			return false
+		}
+		unified := true
+		for i, param := range a.parameters {
+			unified = c.unifies(param, b.parameters[i]) && unified
+		}
+		return c.unifies(a.result, b.result) && unified
+	default:
+		return a == b
+	}
+}
+
+func (c *checker) bind(v *TypeVariable, t Type) bool {
+	if v == t {
+		return true
+	}
+	if occurs(v, t) {
+		return false
+	}
+	if len(v.fields) > 0 {
+		if _, ok := t.(RecordType); !ok {
+			if _, ok := t.(*TypeVariable); !ok {
+				return false
+			}
+		}
+	}
+	v.instance = t
+	v.boundAt = c.pos
+	// the fields that were accessed must exist with the same types
+	unified := true
+	for _, field := range v.fields {
+		if record, ok := t.(RecordType); ok {
+			index, exists := record.field(field.name)
+			if !exists {
+				v.instance = nil
+				return false
+			}
+			unified = c.unifies(field.typ, record.fields[index].typ) && unified
+		} else {
+			unified = c.unifies(field.typ, c.fieldOf(t.(*TypeVariable), field.name)) && unified
+		}
+	}
+	return unified
+}
+
+func occurs(v *TypeVariable, t Type) bool {
+	switch t := prune(t).(type) {
+	case *TypeVariable:
+		return t == v || slices.ContainsFunc(t.fields, func(field RecordField) bool {
+			return occurs(v, field.typ)
+		})
+	case ArrayType:
+		return occurs(v, t.element)
+	case RecordType:
+		return slices.ContainsFunc(t.fields, func(field RecordField) bool {
+			return occurs(v, field.typ)
+		})
+	case FunctionType:
+		for _, param := range t.parameters {
+			if occurs(v, param) {
+				return true
+			}
+		}
+		return occurs(v, t.result)
+	default:
+		return false
+	}
+}
+
+func (c *checker) mismatch(a, b Type) {
+	describe := func(t Type) string {
+		resolved, pos, located := resolve(t)
+		if located {
+			return fmt.Sprintf("%s (from %s)", typeString(resolved), pos)
+		}
+		return typeString(resolved)
+	}
+	c.errorf("type mismatch: %s and %s", describe(a), describe(b))
+}
+
+// freeTypeVariables collects the unbound variables of t that are not in bound.
+func freeTypeVariables(t Type, bound map[*TypeVariable]bool, free *[]*TypeVariable) {
+	switch t := prune(t).(type) {
+	case *TypeVariable:
+		if !bound[t] {
+			bound[t] = true
+			*free = append(*free, t)
+			for _, field := range t.fields {
+				freeTypeVariables(field.typ, bound, free)
+			}
+		}
+	case ArrayType:
+		freeTypeVariables(t.element, bound, free)
+	case RecordType:
+		for _, field := range t.fields {
+			freeTypeVariables(field.typ, bound, free)
+		}
+	case FunctionType:
+		for _, param := range t.parameters {
+			freeTypeVariables(param, bound, free)
+		}
+		freeTypeVariables(t.result, bound, free)
+	}
+}
+
+// generalize quantifies the variables of t that no enclosing scope uses.
+func (c *checker) generalize(t Type) Scheme {
+	inScope := make(map[*TypeVariable]bool)
+	ignored := []*TypeVariable{}
+	for scope := c.scope; scope != nil; scope = scope.parent {
+		for _, scheme := range scope.names {
+			for _, v := range scheme.variables {
+				inScope[v] = true
+			}
+			freeTypeVariables(scheme.typ, inScope, &ignored)
+		}
+	}
+	scheme := Scheme{typ: t}
+	freeTypeVariables(t, inScope, &scheme.variables)
+	return scheme
+}
+
+func (c *checker) instantiate(scheme Scheme) Type {
+	if len(scheme.variables) == 0 {
+		return scheme.typ
+	}
+	substitution := make(map[*TypeVariable]Type)
+	for _, v := range scheme.variables {
+		substitution[v] = c.fresh()
+	}
+	for _, v := range scheme.variables {
+		fresh := substitution[v].(*TypeVariable)
+		for _, field := range v.fields {
+			fresh.fields = append(fresh.fields, RecordField{field.name, substitute(field.typ, substitution)})
+		}
+	}
+	return substitute(scheme.typ, substitution)
+}
+
+// substitute replaces variables, keeping bound variables that do not
+// contain any so that their positions survive.
+func substitute(t Type, substitution map[*TypeVariable]Type) Type {
+	switch t := t.(type) {
+	case *TypeVariable:
+		if replacement, ok := substitution[t]; ok {
+			return replacement
+		}
+		if t.instance != nil && mentions(t.instance, substitution) {
+			return substitute(t.instance, substitution)
+		}
+		return t
+	case ArrayType:
+		return ArrayType{element: substitute(t.element, substitution)}
+	case RecordType:
+		fields := []RecordField{}
+		for _, field := range t.fields {
+			fields = append(fields, RecordField{field.name, substitute(field.typ, substitution)})
+		}
+		return RecordType{fields: fields}
+	case FunctionType:
+		parameters := []Type{}
+		for _, param := range t.parameters {
+			parameters = append(parameters, substitute(param, substitution))
+		}
+		return FunctionType{parameters: parameters, result: substitute(t.result, substitution)}
+	default:
+		return t
+	}
+}
+
+func mentions(t Type, substitution map[*TypeVariable]Type) bool {
+	variables := []*TypeVariable{}
+	freeTypeVariables(t, make(map[*TypeVariable]bool), &variables)
+	for _, v := range variables {
+		if _, ok := substitution[v]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func positionOf(node AST) (Position, bool) {
+	switch node := node.(type) {
+	case Id:
+		return node.pos, true
+	case Call:
+		return node.pos, true
+	case Return:
+		return node.pos, true
+	case Assign:
+		return node.pos, true
+	case Var:
+		return node.pos, true
+	case Function:
+		return node.pos, true
+	case FunctionExpression:
+		return node.pos, true
+	case Record:
+		return node.pos, true
+	case Member:
+		return node.pos, true
+	case MemberAssign:
+		return node.pos, true
+	case Switch:
+		return node.pos, true
+	case Break:
+		return node.pos, true
+	case Continue:
+		return node.pos, true
+	case Extern:
+		return node.pos, true
+	default:
+		return Position{}, false
+	}
+}
+
+func (c *checker) check(node AST) Type {
+	if pos, ok := positionOf(node); ok {
+		outer := c.pos
+		c.pos = pos
+		defer func() { c.pos = outer }()
+	}
+
+	switch node := node.(type) {
+	case Number:
+		return c.located(NumberType{})
+	case Boolean:
+		return c.located(BooleanType{})
+	case Id:
+		if scheme, exists := c.scope.lookup(node.value); exists {
+			return c.instantiate(scheme)
+		}
+		if scheme, exists := c.functions[node.value]; exists {
+			return c.instantiate(scheme)
+		}
+		c.errorf("undefined variable %s", node.value)
+		return c.fresh()
+	case Not:
+		// any value can be tested for truth
+		c.check(node.term)
+		return c.located(BooleanType{})
+	case Negate:
+		c.unify(c.check(node.term), c.located(NumberType{}))
+		return c.located(NumberType{})
+	case Add, Subtract, Multiply, Divide, Modulo:
+		for _, operand := range children(node) {
+			c.unify(c.check(operand), c.located(NumberType{}))
+		}
+		return c.located(NumberType{})
+	case Equal, NotEqual:
+		operands := children(node)
+		c.unify(c.check(operands[0]), c.check(operands[1]))
+		return c.located(BooleanType{})
+	case Call:
+		return c.checkCall(node)
+	case Record:
+		fields := []RecordField{}
+		for _, field := range node.fields {
+			if slices.ContainsFunc(fields, func(other RecordField) bool { return other.name == field.name }) {
+				c.errorf("duplicate field %s", field.name)
+				continue
+			}
+			fields = append(fields, RecordField{name: field.name, typ: c.check(field.value)})
+		}
+		return c.located(NewRecordType(fields))
+	case Member:
+		return c.checkField(node.object, node.field)
+	case MemberAssign:
+		value := c.check(node.value)
+		c.unify(c.checkField(node.object, node.field), value)
+		return value
+	case Return:
+		c.unify(c.result, c.check(node.term))
+		return c.located(VoidType{})
+	case Block:
+		c.enterScope()
+		for _, statement := range node.statements {
+			c.check(statement)
+		}
+		c.exitScope()
+		return c.located(VoidType{})
+	case Switch:
+		c.unify(c.check(node.value), c.located(NumberType{}))
+		values := map[int]bool{}
+		defaults := 0
+		for _, clause := range node.cases {
+			if clause.isDefault {
+				defaults++
+			} else if values[clause.value] {
+				c.errorf("duplicate case %d", clause.value)
+			}
+			values[clause.value] = true
+		}
+		if defaults > 1 {
+			c.errorf("switch has %d default clauses", defaults)
+		}
+		c.enterScope()
+		c.breakable++
+		for _, statement := range node.statements() {
+			c.check(statement)
+		}
+		c.breakable--
+		c.exitScope()
+		return c.located(VoidType{})
+	case While, For:
+		c.enterScope()
+		parts := children(node)
+		for _, child := range parts[:len(parts)-1] {
+			c.check(child)
+		}
+		// break and continue apply to the body, which comes last
+		c.loops++
+		c.breakable++
+		c.check(parts[len(parts)-1])
+		c.loops--
+		c.breakable--
+		c.exitScope()
+		return c.located(VoidType{})
+	case Break:
+		if c.breakable == 0 {
+			c.errorf("break statement outside of a loop or switch")
+		}
+		return c.located(VoidType{})
+	case Continue:
+		if c.loops == 0 {
+			c.errorf("continue statement outside of a loop")
+		}
+		return c.located(VoidType{})
+	case If:
+		c.enterScope()
+		for _, child := range children(node) {
+			c.check(child)
+		}
+		c.exitScope()
+		return c.located(VoidType{})
+	case Assign:
+		value := c.check(node.value)
+		if scheme, exists := c.scope.lookup(node.name); exists {
+			c.unify(scheme.typ, value)
+		} else {
+			c.errorf("undefined variable %s", node.name)
+		}
+		return value
+	case Var:
+		c.checkVar(node)
+		return c.located(VoidType{})
+	case Extern:
+		// checkProgram declares the externs of the top level
+		c.errorf("extern function %s must be at the top level", node.name)
+		return c.located(VoidType{})
+	case Function:
+		c.checkFunction(node.parameters, node.signature, node.body)
+		return c.located(VoidType{})
+	case FunctionExpression:
+		return c.checkFunction(node.parameters, node.signature, node.body)
+	case Main:
+		c.checkFunction([]string{}, FunctionType{parameters: []Type{}}, Block{statements: node.statements})
+		return c.located(VoidType{})
+	case Program:
+		c.checkProgram(node)
+		return c.located(VoidType{})
+	default:
+		for _, child := range children(node) {
+			c.check(child)
+		}
+		return c.located(VoidType{})
+	}
+}
+
+func (c *checker) checkVar(node Var) {
+	typ := c.annotation(node.typ)
+	_, recursive := node.value.(FunctionExpression)
+	if recursive {
+		c.scope.names[node.name] = monomorphic(typ)
+	}
+	c.unify(typ, c.check(node.value))
+	if recursive && !c.assigned[node.name] {
+		// only functions that are never reassigned are generalized, so
+		// an assignment cannot use the type at two instances
+		delete(c.scope.names, node.name)
+		c.scope.names[node.name] = c.generalize(typ)
+	} else {
+		c.scope.names[node.name] = monomorphic(typ)
+	}
+}
+
+// collectAssigned records the names that node assigns to, within nested
+// functions too. Shadowed names are not told apart, which only keeps more
+// variables monomorphic.
+func (c *checker) collectAssigned(node AST) {
+	if assign, ok := node.(Assign); ok {
+		c.assigned[assign.name] = true
+	}
+	for _, child := range children(node) {
+		c.collectAssigned(child)
+	}
+}
+
+// checkField returns the type of a field of object and records the access
+// so that its offset can be fixed once the object's type is known.
+func (c *checker) checkField(object AST, name string) Type {
+	typ := c.check(object)
+	c.accesses = append(c.accesses, fieldAccess{object: typ, field: name, pos: c.pos})
+	switch record := prune(typ).(type) {
+	case RecordType:
+		if index, exists := record.field(name); exists {
+			return record.fields[index].typ
+		}
+		c.errorf("%s has no field %s", record, name)
+	case *TypeVariable:
+		return c.fieldOf(record, name)
+	default:
+		c.errorf("%s is not a record, so it has no field %s", typeString(record), name)
+	}
+	return c.fresh()
+}
+
+// fieldOf returns the type of a field of an unknown record, adding the
+// field to the variable's constraints when it is new.
+func (c *checker) fieldOf(v *TypeVariable, name string) Type {
+	for _, field := range v.fields {
+		if field.name == name {
+			return field.typ
+		}
+	}
+	typ := c.fresh()
+	v.fields = append(v.fields, RecordField{name: name, typ: typ})
+	return typ
+}
+
+func (c *checker) checkCall(call Call) Type {
+	var callee Type
+	if scheme, exists := c.scope.lookup(call.callee); exists {
+		callee = c.instantiate(scheme)
+	} else if scheme, exists := c.functions[call.callee]; exists {
+		callee = c.instantiate(scheme)
+	}
+
+	args := []Type{}
+	for _, arg := range call.args {
+		args = append(args, c.check(arg))
+	}
+	result := c.fresh()
+	if callee == nil {
+		c.errorf("undefined function %s", call.callee)
+		return result
+	}
+
+	function, ok := prune(callee).(FunctionType)
+	if !ok {
+		c.unify(callee, FunctionType{parameters: args, result: result})
+		return result
+	}
+	if len(function.parameters) != len(args) {
+		c.errorf("%s expects %d arguments, got %d", call.callee, len(function.parameters), len(args))
+		return result
+	}
+	// unifying argument by argument points conflicts at the parameter
+	for i, arg := range args {
+		c.unify(function.parameters[i], arg)
+	}
+	c.unify(function.result, result)
+	return result
+}
+
+// checkFunction infers the type of a function from its annotations and
+// its body. A body without any return statement returns void.
+func (c *checker) checkFunction(parameters []string, signature FunctionType, body AST) Type {
+	function := c.signatureType(signature)
+	c.checkBody(parameters, function, body)
+	return function
+}
+
+func (c *checker) signatureType(signature FunctionType) FunctionType {
+	function := FunctionType{parameters: []Type{}, result: c.annotation(signature.result)}
+	for _, param := range signature.parameters {
+		function.parameters = append(function.parameters, c.annotation(param))
+	}
+	return function
+}
+
+func (c *checker) checkBody(parameters []string, function FunctionType, body AST) {
+	outerResult, outerLoops, outerBreakable := c.result, c.loops, c.breakable
+	c.result = function.result
+	// a function body starts outside of any loop
+	c.loops, c.breakable = 0, 0
+	c.enterScope()
+	for i, param := range parameters {
+		c.scope.names[param] = monomorphic(function.parameters[i])
+	}
+	c.check(body)
+	if !containsReturn(body) {
+		c.unify(function.result, c.located(VoidType{}))
+	}
+	c.exitScope()
+	c.result, c.loops, c.breakable = outerResult, outerLoops, outerBreakable
+}
+
+func containsReturn(node AST) bool {
+	if _, ok := node.(Return); ok {
+		return true
+	}
+	if _, ok := node.(FunctionExpression); ok {
+		return false
+	}
+	for _, child := range children(node) {
+		if containsReturn(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProgram infers the top-level functions one strongly connected
+// component of the call graph at a time, callees first, so that each
+// function is generalized before its callers use it. Globals are
+// monomorphic and their initializers run after all functions are known.
+func (c *checker) checkProgram(program Program) {
+	functions := map[string]Function{}
+	names := []string{}
+	for _, statement := range program.statements {
+		switch statement := statement.(type) {
+		case Function:
+			functions[statement.name] = statement
+			names = append(names, statement.name)
+		case Var:
+			c.scope.names[statement.name] = monomorphic(c.annotation(statement.typ))
+		case Extern:
+			c.checkExtern(statement)
+		}
+	}
+
+	for _, component := range stronglyConnected(names, func(name string) []string {
+		function := functions[name]
+		callees := []string{}
+		for _, free := range freeVariables(function.parameters, function.body) {
+			if _, ok := functions[free]; ok {
+				callees = append(callees, free)
+			}
+		}
+		return callees
+	}) {
+		types := map[string]FunctionType{}
+		for _, name := range component {
+			outer := c.pos
+			c.pos = functions[name].pos
+			types[name] = c.signatureType(functions[name].signature)
+			c.pos = outer
+			c.functions[name] = monomorphic(types[name])
+		}
+		for _, name := range component {
+			function := functions[name]
+			outer := c.pos
+			c.pos = function.pos
+			c.checkBody(function.parameters, types[name], function.body)
+			c.pos = outer
+		}
+		for _, name := range component {
+			c.functions[name] = c.generalize(types[name])
+		}
+	}
+
+	for _, statement := range program.statements {
+		switch statement := statement.(type) {
+		case Function, Extern:
+		case Var:
+			outer := c.pos
+			c.pos = statement.pos
+			global, _ := c.scope.lookup(statement.name)
+			c.unify(global.typ, c.check(statement.value))
+			c.pos = outer
+		default:
+			c.check(statement)
+		}
+	}
+
+	externs := map[string]bool{}
+	for _, statement := range program.statements {
+		switch statement := statement.(type) {
+		case Extern:
+			if !externs[statement.name] {
+				externs[statement.name] = true
+				c.signatures = append(c.signatures,
+					fmt.Sprintf("extern function %s%s", statement.name,
+						formatScheme(c.functions[statement.name], statement.parameters)))
+			}
+		case Var:
+			global, _ := c.scope.lookup(statement.name)
+			c.signatures = append(c.signatures,
+				fmt.Sprintf("var %s: %s", statement.name, formatScheme(global, nil)))
+		case Function:
+			c.signatures = append(c.signatures,
+				fmt.Sprintf("function %s%s", statement.name,
+					formatScheme(c.functions[statement.name], statement.parameters)))
+		}
+	}
+}
+
+// checkExtern declares an external function. C functions take and return
+// words, so an unannotated parameter accepts any value and an unannotated
+// result is a number. Declarations of the same function must agree.
+func (c *checker) checkExtern(extern Extern) {
+	outer := c.pos
+	c.pos = extern.pos
+	defer func() { c.pos = outer }()
+
+	function := c.signatureType(extern.signature)
+	if extern.signature.result == nil {
+		c.unify(function.result, c.located(NumberType{}))
+	}
+	if previous, exists := c.functions[extern.name]; exists {
+		c.unify(c.instantiate(previous), function)
+		return
+	}
+	c.functions[extern.name] = c.generalize(function)
+}
+
+// stronglyConnected returns the strongly connected components of a graph
+// in reverse topological order, using Tarjan's algorithm.
+func stronglyConnected(nodes []string, edges func(string) []string) [][]string {
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	components := [][]string{}
+
+	var visit func(node string)
+	visit = func(node string) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, next := range edges(node) {
+			if _, visited := index[next]; !visited {
+				visit(next)
+				lowLink[node] = min(lowLink[node], lowLink[next])
+			} else if onStack[next] {
+				lowLink[node] = min(lowLink[node], index[next])
+			}
+		}
+
+		if lowLink[node] == index[node] {
+			component := []string{}
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+
+	for _, node := range nodes {
+		if _, visited := index[node]; !visited {
+			visit(node)
+		}
+	}
+	return components
+}
+
+// formatScheme prints a scheme with its variables named A, B, ... and,
+// given parameter names, as a function signature such as
+// <A>(x: A): A.
+func formatScheme(scheme Scheme, parameters []string) string {
+	names := map[*TypeVariable]string{}
+	variables := []*TypeVariable{}
+	freeTypeVariables(scheme.typ, make(map[*TypeVariable]bool), &variables)
+	for i, v := range variables {
+		names[v] = string(rune('A' + i%26))
+		if i >= 26 {
+			names[v] += fmt.Sprint(i / 26)
+		}
+	}
+
+	var format func(t Type) string
+	format = func(t Type) string {
+		switch t := prune(t).(type) {
+		case *TypeVariable:
+			return names[t]
+		case ArrayType:
+			return fmt.Sprintf("Array<%s>", format(t.element))
+		case RecordType:
+			return formatFields(t.fields, "", format)
+		case FunctionType:
+			params := []string{}
+			for _, param := range t.parameters {
+				params = append(params, format(param))
+			}
+			return fmt.Sprintf("(%s) => %s", strings.Join(params, ", "), format(t.result))
+		default:
+			return t.String()
+		}
+	}
+
+	generics := ""
+	if len(scheme.variables) > 0 {
+		quantified := []string{}
+		for _, v := range scheme.variables {
+			if name, ok := names[v]; ok {
+				if len(v.fields) > 0 {
+					name += ": " + formatFields(v.fields, "...", format)
+				}
+				quantified = append(quantified, name)
+			}
+		}
+		generics = fmt.Sprintf("<%s>", strings.Join(quantified, ", "))
+	}
+
+	function, ok := prune(scheme.typ).(FunctionType)
+	if parameters == nil || !ok {
+		return generics + format(scheme.typ)
+	}
+	params := []string{}
+	for i, param := range function.parameters {
+		params = append(params, fmt.Sprintf("%s: %s", parameters[i], format(param)))
+	}
+	return fmt.Sprintf("%s(%s): %s", generics, strings.Join(params, ", "), format(function.result))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+// allocFunction returns r0 bytes of zeroed, garbage-collected memory in r0.
+const allocFunction = "__baseline_alloc"
+
+// closureParameter is the hidden last parameter through which a closure
+// receives its environment. It cannot clash with an identifier.
+const closureParameter = "$env"
+
+// staticClosure is the label of the closure object for a top-level
+// function used as a value. Its environment is empty.
+func staticClosure(function string) string {
+	return fmt.Sprintf(".Lclosure_%s", function)
+}
+
+// FunctionExpression is an anonymous function used as a value. It
+// evaluates to a closure: a heap object holding the code address and an
+// environment with the cells of the variables it captures.
+type FunctionExpression struct {
+	parameters []string
+	signature  FunctionType
+	body       AST
+	pos        Position
+}
+
+// captures returns the variables of env the closure captures, which are
+// the free variables that are neither globals nor functions.
+func (fe FunctionExpression) captures(env *Environment) []string {
+	captures := []string{}
+	for _, name := range freeVariables(fe.parameters, fe.body) {
+		_, local := env.lookup(name)
+		_, captured := env.captures[name]
+		if local || captured {
+			captures = append(captures, name)
+		}
+	}
+	return captures
+}
+
+// function returns the code of the closure as a function named by label,
+// which takes the environment as its last parameter.
+func (fe FunctionExpression) function(label *Label) Function {
+	return Function{
+		name:       label.String(),
+		parameters: append(append([]string{}, fe.parameters...), closureParameter),
+		body:       fe.body,
+	}
+}
+
+func (fe FunctionExpression) Equals(other AST) bool {
+	if otherFunc, ok := other.(*FunctionExpression); ok {
+		if len(fe.parameters) != len(otherFunc.parameters) {
+			return false
+		}
+		for i, param := range fe.parameters {
+			if param != otherFunc.parameters[i] {
+				return false
+			}
+		}
+		return fe.body.Equals(otherFunc.body)
+	}
+	return false
+}
+
+// freeVariables returns, in order of first use, the names a function
+// refers to without declaring them. These include globals and functions,
+// which the closure does not capture.
+func freeVariables(parameters []string, body AST) []string {
+	collector := &freeVariableCollector{seen: make(map[string]bool)}
+	bound := make(map[string]bool)
+	for _, param := range parameters {
+		bound[param] = true
+	}
+	collector.visit(body, bound)
+	return collector.names
+}
+
+type freeVariableCollector struct {
+	names []string
+	seen  map[string]bool
+}
+
+func (c *freeVariableCollector) use(name string, bound map[string]bool) {
+	if !bound[name] && !c.seen[name] {
+		c.seen[name] = true
+		c.names = append(c.names, name)
+	}
+}
+
+func (c *freeVariableCollector) visit(node AST, bound map[string]bool) {
+	switch node := node.(type) {
+	case Id:
+		c.use(node.value, bound)
+	case Call:
+		c.use(node.callee, bound)
+		for _, arg := range node.args {
+			c.visit(arg, bound)
+		}
+	case Assign:
+		c.use(node.name, bound)
+		c.visit(node.value, bound)
+	case Var:
+		if isRecursive(node) {
+			bound[node.name] = true
+		}
+		c.visit(node.value, bound)
+		bound[node.name] = true
+	case FunctionExpression:
+		for _, name := range freeVariables(node.parameters, node.body) {
+			c.use(name, bound)
+		}
+	case Block, If, While, For, Switch:
+		// declarations inside do not outlive the statement
+		scope := maps.Clone(bound)
+		for _, child := range children(node) {
+			c.visit(child, scope)
+		}
+	default:
+		for _, child := range children(node) {
+			c.visit(child, bound)
+		}
+	}
+}
+
+// capturedVariables returns the names that closures created directly in
+// body capture. Every local of that name is boxed, which is conservative
+// when a closure's free variable is shadowed.
+func capturedVariables(body AST) map[string]bool {
+	captured := make(map[string]bool)
+	var visit func(node AST)
+	visit = func(node AST) {
+		if function, ok := node.(FunctionExpression); ok {
+			for _, name := range freeVariables(function.parameters, function.body) {
+				captured[name] = true
+			}
+			return
+		}
+		for _, child := range children(node) {
+			visit(child)
+		}
+	}
+	visit(body)
+	return captured
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// register is one of the registers the generator names. Expressions leave
+// their value in the accumulator; the operand register holds the left
+// operand of a binary operator, an address to store to or the second
+// argument of a runtime function.
+type register int
+
+const (
+	accumulator register = iota
+	operand
+	framePointer
+)
+
+// operator is a binary operator on numbers, or a comparison.
+type operator int
+
+const (
+	addition operator = iota
+	subtraction
+	multiplication
+	division
+	remainder
+	equality
+	inequality
+)
+
+// machine is an instruction set the generator emits code for. Numbers are
+// 32-bit on every target, so arithmetic wraps at 32 bits and leaves its
+// result sign-extended to the word.
+//
+// A frame starts with a frame record of two words, padded to the alignment
+// of the stack: the caller's frame pointer, which the frame pointer points
+// to, and the return address above it. The parameters passed in registers
+// are stored below it, followed by the locals.
+type machine interface {
+	// wordSize is the size of a value, a stack slot and a field.
+	wordSize() int
+	// argumentRegisters is the number of arguments passed in registers.
+	argumentRegisters() int
+	// stackAlignment is the alignment of the stack pointer at calls.
+	stackAlignment() int
+
+	// emitPrologue links a new frame, reserves frameSize bytes below it and
+	// stores the first parameters there.
+	emitPrologue(parameters, frameSize int)
+	emitReturn()
+	emitFunctionEnd()
+	// emitSetStackBase records the frame of main for the collector.
+	emitSetStackBase()
+
+	emitNumber(to register, value int)
+	emitAddress(to register, label string)
+	emitMove(to, from register)
+	emitLoad(to, base register, offset int)
+	emitStore(from, base register, offset int)
+
+	// emitPush pushes the accumulator; emitPop pops into the operand
+	// register and emitPeek loads the top of the stack into it.
+	emitPush()
+	emitPop()
+	emitPeek()
+
+	emitReserve(size int)
+	emitRelease(size int)
+	// emitStoreArgument stores the accumulator into the argument area at
+	// the top of the stack, and emitLoadArguments loads the first count
+	// words of the area into the argument registers.
+	emitStoreArgument(offset int)
+	emitLoadArguments(count int)
+	emitCall(function string)
+	// emitPrepareClosureCall stores the environment of the closure in the
+	// accumulator into the argument area and keeps its code address for
+	// emitCallClosure.
+	emitPrepareClosureCall(environmentOffset int)
+	emitCallClosure()
+
+	emitNot()
+	emitNegate()
+	// emitBinary combines the operand register with the accumulator.
+	emitBinary(op operator)
+	emitBranchIfZero(target *Label)
+	emitBranchIfEqual(value int, target *Label)
+	emitJump(target *Label)
+	// emitJumpTable jumps to table[accumulator-low], or to otherwise when
+	// the accumulator is out of range.
+	emitJumpTable(low int, table []*Label, otherwise *Label)
+
+	// runtime returns the allocator, collector and field lookup.
+	runtime() string
+}
+
+// targets are the machines the generator emits assembly for.
+var targets = map[string]machine{
+	"arm":     arm{},
+	"aarch64": aarch64{},
+	"riscv32": riscv32{},
+}
+
+// translator generates a program in a language other than assembly.
+type translator interface {
+	emit(node AST, env *Environment)
+}
+
+// translators make the translator of each such target.
+var translators = map[string]func() translator{
+	"wasm": func() translator { return &wasmGenerator{} },
+	"c":    func() translator { return &cGenerator{} },
+	"llvm": func() translator { return &llvmGenerator{} },
+}
+
+// generator emits a program for a machine by walking the AST: operands
+// waiting for the other side of an operator are pushed on the stack, where
+// the collector finds them, and every variable lives in a stack slot.
+type generator struct {
+	machine machine
+}
+
+// frameRecordSize is the size of the frame record, padded to the
+// alignment of the stack.
+func (g generator) frameRecordSize() int {
+	return g.alignStack(2 * g.machine.wordSize())
+}
+
+// alignStack rounds size up to the alignment the stack pointer keeps.
+func (g generator) alignStack(size int) int {
+	alignment := g.machine.stackAlignment()
+	return (size + alignment - 1) &^ (alignment - 1)
+}
+
+// dataDirective emits a word of data.
+func (g generator) dataDirective() string {
+	if g.machine.wordSize() == 8 {
+		return ".quad"
+	}
+	return ".word"
+}
+
+func (g generator) emit(node AST, env *Environment) {
+	m := g.machine
+	switch node := node.(type) {
+	case Program:
+		g.emitProgram(node, env)
+	case Function:
+		g.emitFunction(node, env)
+	case Main:
+		g.emitFunction(Function{name: "main", parameters: []string{}, body: Block{statements: node.statements}}, env)
+	case Number:
+		m.emitNumber(accumulator, node.value)
+	case Boolean:
+		if node.value {
+			m.emitNumber(accumulator, 1)
+		} else {
+			m.emitNumber(accumulator, 0)
+		}
+	case Id:
+		g.emitLoadVariable(node.value, env)
+	case Not:
+		g.emit(node.term, env)
+		m.emitNot()
+	case Negate:
+		g.emit(node.term, env)
+		m.emitNegate()
+	case Equal:
+		g.emitBinary(node.left, node.right, equality, env)
+	case NotEqual:
+		g.emitBinary(node.left, node.right, inequality, env)
+	case Add:
+		g.emitBinary(node.left, node.right, addition, env)
+	case Subtract:
+		g.emitBinary(node.left, node.right, subtraction, env)
+	case Multiply:
+		g.emitBinary(node.left, node.right, multiplication, env)
+	case Divide:
+		g.emitBinary(node.left, node.right, division, env)
+	case Modulo:
+		g.emitBinary(node.left, node.right, remainder, env)
+	case Call:
+		g.emitCall(node, env)
+	case Return:
+		g.emit(node.term, env)
+		m.emitReturn()
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			g.emit(statement, scope)
+		}
+	case If:
+		g.emitIf(node, env)
+	case While:
+		loopStart := NewLabel()
+		loopEnd := NewLabel()
+		emit(fmt.Sprintf("%s:", loopStart))
+		g.emit(node.conditional, env)
+		m.emitBranchIfZero(loopEnd)
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStart})
+		g.emit(node.body, body)
+		m.emitJump(loopStart)
+		emit(fmt.Sprintf("%s:", loopEnd))
+	case For:
+		g.emitFor(node, env)
+	case Switch:
+		g.emitSwitch(node, env)
+	case Break:
+		loop, ok := env.innermostLoop()
+		if !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		m.emitJump(loop.breakLabel)
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		m.emitJump(loop.continueLabel)
+	case Assign:
+		g.emit(node.value, env)
+		g.emitStoreVariable(node.name, env)
+	case Var:
+		// the initializer still sees an outer variable of the same name,
+		// except that a function expression can refer to itself
+		if isRecursive(node) {
+			m.emitNumber(accumulator, 0)
+			g.declare(node, env)
+			g.emit(node.value, env)
+			g.emitStoreVariable(node.name, env)
+			return
+		}
+		g.emit(node.value, env)
+		g.declare(node, env)
+	case FunctionExpression:
+		g.emitClosure(node, env)
+	case Record:
+		g.emitRecord(node, env)
+	case Member:
+		g.emit(node.object, env)
+		if index, known := env.symbols.fields[node.pos]; known {
+			m.emitLoad(accumulator, accumulator, m.wordSize()*(index+1))
+			return
+		}
+		g.emitFieldAddress(node.field, env)
+		m.emitLoad(accumulator, accumulator, 0)
+	case MemberAssign:
+		g.emitMemberAssign(node, env)
+	case Assert:
+		pass := NewLabel()
+		end := NewLabel()
+		g.emit(node.condition, env)
+		m.emitBranchIfEqual(1, pass)
+		m.emitNumber(accumulator, 'F')
+		m.emitJump(end)
+		emit(fmt.Sprintf("%s:", pass))
+		m.emitNumber(accumulator, '.')
+		emit(fmt.Sprintf("%s:", end))
+		m.emitCall("putchar")
+	default:
+		panic(misplaced(node))
+	}
+}
+
+func (g generator) emitProgram(p Program, env *Environment) {
+	word := g.machine.wordSize()
+	parts := p.split(env)
+	for _, name := range parts.externs {
+		emit(fmt.Sprintf(".extern %s", name))
+	}
+
+	// the collector scans the globals between these labels for roots
+	emit(".data")
+	emit(fmt.Sprintf(".balign %d", word))
+	emit("__baseline_data_start:")
+	for _, global := range parts.initialized {
+		emit(fmt.Sprintf("%s:", globalSymbol(global.name)))
+		emit(fmt.Sprintf("  %s %d", g.dataDirective(), global.value.(Number).value))
+	}
+	emit("__baseline_data_end:")
+	emit(".bss")
+	emit(fmt.Sprintf(".balign %d", word))
+	emit("__baseline_bss_start:")
+	for _, name := range parts.uninitialized {
+		emit(fmt.Sprintf("%s:", globalSymbol(name)))
+		emit(fmt.Sprintf("  .space %d", word))
+	}
+	emit("__baseline_bss_end:")
+	emit(".text")
+
+	g.emitFunction(parts.init(), env)
+	for _, function := range parts.functions {
+		g.emit(function, env)
+	}
+
+	emit(fmt.Sprintf(g.machine.runtime(), heapSize))
+
+	if closures := parts.closures(env); len(closures) > 0 {
+		emit(".data")
+		emit(fmt.Sprintf(".balign %d", word))
+		for _, name := range closures {
+			emit(fmt.Sprintf("%s:", staticClosure(name)))
+			emit(fmt.Sprintf("  %s %s, 0", g.dataDirective(), name))
+		}
+	}
+	env.symbols.emitShapes()
+}
+
+func (g generator) emitFunction(f Function, env *Environment) {
+	emit("")
+	emit(fmt.Sprintf(".global %s", f.name))
+	emit(fmt.Sprintf("%s:", f.name))
+	g.emitBody(f, env, nil)
+}
+
+// emitBody emits the frame and body of a function. captures numbers the
+// variables in the environment of a closure.
+func (g generator) emitBody(f Function, outer *Environment, captures map[string]int) {
+	m := g.machine
+	word := m.wordSize()
+	parameters := min(len(f.parameters), m.argumentRegisters())
+	m.emitPrologue(parameters, g.alignStack(word*(parameters+localSlots(f.body))))
+	if f.name == "main" {
+		// the collector stops walking frames at main
+		m.emitSetStackBase()
+		m.emitCall(initFunction)
+	}
+
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(f.body)
+	env.captures = captures
+	for i, param := range f.parameters {
+		if i < parameters {
+			env.locals[param] = -word * (i + 1)
+		} else {
+			// stack arguments sit above the frame record
+			env.locals[param] = g.frameRecordSize() + word*(i-parameters)
+		}
+	}
+	env.nextLocalOffset = -word * parameters
+	for _, param := range f.parameters {
+		if env.boxed[param] {
+			offset := env.locals[param]
+			m.emitLoad(accumulator, framePointer, offset)
+			g.emitBox()
+			m.emitStore(accumulator, framePointer, offset)
+		}
+	}
+
+	g.emit(f.body, env)
+	m.emitNumber(accumulator, 0)
+	m.emitReturn()
+	m.emitFunctionEnd()
+}
+
+// declare binds a variable to the next free slot and stores the
+// accumulator into it.
+func (g generator) declare(v Var, env *Environment) {
+	if _, exists := env.locals[v.name]; exists {
+		panic(fmt.Sprintf("Variable already declared in this scope: %s", v.name))
+	}
+	env.nextLocalOffset -= g.machine.wordSize()
+	env.locals[v.name] = env.nextLocalOffset
+	if env.boxed[v.name] {
+		g.emitBox()
+	}
+	g.machine.emitStore(accumulator, framePointer, env.nextLocalOffset)
+}
+
+// emitBox moves the accumulator into a freshly allocated heap cell and
+// leaves the cell's address in the accumulator.
+func (g generator) emitBox() {
+	m := g.machine
+	m.emitPush()
+	m.emitNumber(accumulator, m.wordSize())
+	m.emitCall(allocFunction)
+	m.emitPop()
+	m.emitStore(operand, accumulator, 0)
+}
+
+// emitCell loads the address of the heap cell of a boxed or captured
+// variable into register.
+func (g generator) emitCell(name string, to register, env *Environment) {
+	if offset, exists := env.lookup(name); exists {
+		g.machine.emitLoad(to, framePointer, offset)
+		return
+	}
+	offset, _ := env.lookup(closureParameter)
+	g.machine.emitLoad(to, framePointer, offset)
+	g.machine.emitLoad(to, to, g.machine.wordSize()*env.captures[name])
+}
+
+func (g generator) emitLoadVariable(name string, env *Environment) {
+	m := g.machine
+	if offset, exists := env.lookup(name); exists {
+		m.emitLoad(accumulator, framePointer, offset)
+		if env.boxed[name] {
+			m.emitLoad(accumulator, accumulator, 0)
+		}
+	} else if _, exists := env.captures[name]; exists {
+		g.emitCell(name, accumulator, env)
+		m.emitLoad(accumulator, accumulator, 0)
+	} else if env.symbols.globals[name] {
+		m.emitAddress(accumulator, globalSymbol(name))
+		m.emitLoad(accumulator, accumulator, 0)
+	} else if env.symbols.functions[name] {
+		env.symbols.closures[name] = true
+		m.emitAddress(accumulator, staticClosure(name))
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+// emitStoreVariable stores the accumulator into name, leaving it intact.
+func (g generator) emitStoreVariable(name string, env *Environment) {
+	m := g.machine
+	if offset, exists := env.lookup(name); exists {
+		if env.boxed[name] {
+			m.emitLoad(operand, framePointer, offset)
+			m.emitStore(accumulator, operand, 0)
+		} else {
+			m.emitStore(accumulator, framePointer, offset)
+		}
+	} else if _, exists := env.captures[name]; exists {
+		g.emitCell(name, operand, env)
+		m.emitStore(accumulator, operand, 0)
+	} else if env.symbols.globals[name] {
+		m.emitAddress(operand, globalSymbol(name))
+		m.emitStore(accumulator, operand, 0)
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+func (g generator) emitBinary(left, right AST, op operator, env *Environment) {
+	g.emit(left, env)
+	g.machine.emitPush()
+	g.emit(right, env)
+	g.machine.emitPop()
+	g.machine.emitBinary(op)
+}
+
+// argumentArea returns the size of the stack area for count arguments and
+// the part of it holding the arguments not passed in registers, which is
+// left on the stack for the callee.
+func (g generator) argumentArea(count int) (int, int) {
+	word, registers := g.machine.wordSize(), g.machine.argumentRegisters()
+	stacked := g.alignStack(word * max(0, count-registers))
+	return g.alignStack(word*min(count, registers)) + stacked, stacked
+}
+
+// argumentOffset is the offset of the index-th of count arguments in the
+// argument area.
+func (g generator) argumentOffset(index, count int) int {
+	word, registers := g.machine.wordSize(), g.machine.argumentRegisters()
+	if index < registers {
+		return word * index
+	}
+	return g.alignStack(word*min(count, registers)) + word*(index-registers)
+}
+
+// emitArguments evaluates the arguments into a new argument area, leaving
+// room for count in all.
+func (g generator) emitArguments(args []AST, count int, env *Environment) {
+	size, _ := g.argumentArea(count)
+	g.machine.emitReserve(size)
+	for i, arg := range args {
+		g.emit(arg, env)
+		g.machine.emitStoreArgument(g.argumentOffset(i, count))
+	}
+}
+
+// emitLoadArguments moves the first count arguments of the argument area
+// into registers and releases all of the area but the stack arguments.
+func (g generator) emitLoadArguments(count int) {
+	size, stacked := g.argumentArea(count)
+	g.machine.emitLoadArguments(min(count, g.machine.argumentRegisters()))
+	if size > stacked {
+		g.machine.emitRelease(size - stacked)
+	}
+}
+
+func (g generator) emitCall(c Call, env *Environment) {
+	m := g.machine
+	count := len(c.args)
+	_, stacked := g.argumentArea(count)
+	if env.isVariable(c.callee) {
+		// the closure's environment is passed after the declared arguments
+		count++
+		_, stacked = g.argumentArea(count)
+		g.emitArguments(c.args, count, env)
+		g.emitLoadVariable(c.callee, env)
+		m.emitPrepareClosureCall(g.argumentOffset(len(c.args), count))
+		g.emitLoadArguments(count)
+		m.emitCallClosure()
+	} else if count == 0 {
+		m.emitCall(c.callee)
+	} else if count == 1 {
+		g.emit(c.args[0], env)
+		m.emitCall(c.callee)
+	} else {
+		g.emitArguments(c.args, count, env)
+		g.emitLoadArguments(count)
+		m.emitCall(c.callee)
+	}
+	if stacked > 0 {
+		m.emitRelease(stacked)
+	}
+}
+
+func (g generator) emitIf(i If, env *Environment) {
+	m := g.machine
+	ifFalseLabel := NewLabel()
+	endIfLabel := ifFalseLabel
+	if i.alternative != nil {
+		endIfLabel = NewLabel()
+	}
+
+	g.emit(i.conditional, env)
+	m.emitBranchIfZero(ifFalseLabel)
+	g.emit(i.consequence, env.scope())
+	if i.alternative != nil {
+		m.emitJump(endIfLabel)
+		emit(fmt.Sprintf("%s:", ifFalseLabel))
+		g.emit(i.alternative, env.scope())
+	}
+	emit(fmt.Sprintf("%s:", endIfLabel))
+}
+
+func (g generator) emitFor(f For, env *Environment) {
+	m := g.machine
+	loopStart := NewLabel()
+	loopStep := NewLabel()
+	loopEnd := NewLabel()
+
+	// a variable declared in init is visible to the whole loop only
+	scope := env.scope()
+	if f.init != nil {
+		g.emit(f.init, scope)
+	}
+	emit(fmt.Sprintf("%s:", loopStart))
+	if f.conditional != nil {
+		g.emit(f.conditional, scope)
+		m.emitBranchIfZero(loopEnd)
+	}
+	body := scope.scope()
+	body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStep})
+	g.emit(f.body, body)
+	emit(fmt.Sprintf("%s:", loopStep))
+	if f.step != nil {
+		g.emit(f.step, scope)
+	}
+	m.emitJump(loopStart)
+	emit(fmt.Sprintf("%s:", loopEnd))
+}
+
+func (g generator) emitSwitch(s Switch, env *Environment) {
+	plan := s.plan()
+	g.emit(s.value, env)
+	if isDense(plan.values) {
+		low, table := plan.table()
+		g.machine.emitJumpTable(low, table, plan.target)
+	} else {
+		for i, value := range plan.values {
+			g.machine.emitBranchIfEqual(value, plan.caseLabels[i])
+		}
+		g.machine.emitJump(plan.target)
+	}
+
+	scope := plan.scope(env)
+	for i, clause := range s.cases {
+		emit(fmt.Sprintf("%s:", plan.labels[i]))
+		for _, statement := range clause.statements {
+			g.emit(statement, scope)
+		}
+	}
+	emit(fmt.Sprintf("%s:", plan.end))
+}
+
+// emitClosure places the code of a function expression inline, jumped
+// over, and allocates the closure: the code address followed by the
+// environment, an array of the cells of the captured variables.
+func (g generator) emitClosure(fe FunctionExpression, env *Environment) {
+	m := g.machine
+	word := m.wordSize()
+	code := NewLabel()
+	after := NewLabel()
+	captures := fe.captures(env)
+
+	m.emitJump(after)
+	emit(fmt.Sprintf("%s:", code))
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	g.emitBody(fe.function(code), env, indexes)
+	emit(fmt.Sprintf("%s:", after))
+
+	if len(captures) == 0 {
+		m.emitNumber(accumulator, 0)
+	} else {
+		m.emitNumber(accumulator, word*len(captures))
+		m.emitCall(allocFunction)
+	}
+	m.emitPush()
+	for i, name := range captures {
+		g.emitCell(name, accumulator, env)
+		m.emitPeek()
+		m.emitStore(accumulator, operand, word*i)
+	}
+	m.emitNumber(accumulator, 2*word)
+	m.emitCall(allocFunction)
+	m.emitPop()
+	m.emitStore(operand, accumulator, word)
+	m.emitAddress(operand, code.String())
+	m.emitStore(operand, accumulator, 0)
+}
+
+// emitRecord evaluates the fields in source order straight into the new
+// object, which stays on the stack where the collector can see it.
+func (g generator) emitRecord(r Record, env *Environment) {
+	m := g.machine
+	word := m.wordSize()
+	names := layout(r.names())
+	for i := 1; i < len(names); i++ {
+		if names[i] == names[i-1] {
+			panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+		}
+	}
+
+	m.emitNumber(accumulator, word*(len(names)+1))
+	m.emitCall(allocFunction)
+	m.emitAddress(operand, env.symbols.shape(names))
+	m.emitStore(operand, accumulator, 0)
+	m.emitPush()
+	for _, field := range r.fields {
+		g.emit(field.value, env)
+		m.emitPeek()
+		m.emitStore(accumulator, operand, word*(slices.Index(names, field.name)+1))
+	}
+	m.emitPop()
+	m.emitMove(accumulator, operand)
+}
+
+// emitFieldAddress turns the record in the accumulator into the address
+// of its field using the shape, for accesses whose layout the type checker
+// could not determine.
+func (g generator) emitFieldAddress(name string, env *Environment) {
+	g.machine.emitNumber(operand, env.symbols.fieldId(name))
+	g.machine.emitCall(fieldFunction)
+}
+
+func (g generator) emitMemberAssign(ma MemberAssign, env *Environment) {
+	m := g.machine
+	g.emit(ma.object, env)
+	m.emitPush()
+	g.emit(ma.value, env)
+	if index, known := env.symbols.fields[ma.pos]; known {
+		m.emitPop()
+		m.emitStore(accumulator, operand, m.wordSize()*(index+1))
+		return
+	}
+	m.emitPop()
+	m.emitPush()
+	m.emitMove(accumulator, operand)
+	g.emitFieldAddress(ma.field, env)
+	m.emitPop()
+	m.emitStore(operand, accumulator, 0)
+	m.emitMove(accumulator, operand)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -12,13 +13,28 @@ type ParseResult[T any] struct {
 	source *Source
 }
 
+// Source is the remaining input. file names the module it was read from
+// and is empty for a source string. lines holds the index at which each
+// line of str starts, shared by all the sources of one input.
 type Source struct {
 	str   string
 	index int
+	file  string
+	lines []int
 }
 
 func NewSource(str string, index int) *Source {
-	return &Source{str, index}
+	return &Source{str: str, index: index, lines: lineStarts(str)}
+}
+
+func lineStarts(str string) []int {
+	lines := []int{0}
+	for i := range len(str) {
+		if str[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
 }
 
 func (s *Source) Match(pattern string) *ParseResult[string] {
@@ -42,7 +58,31 @@ func (s *Source) Match(pattern string) *ParseResult[string] {
 	match := remaining[loc[0]:loc[1]]
 	return &ParseResult[string]{
 		value:  match,
-		source: NewSource(s.str, s.index+len(match)),
+		source: &Source{str: s.str, index: s.index + len(match), file: s.file, lines: s.lines},
+	}
+}
+
+// Position is a 1-based line and column in the source string, and the
+// file it came from when there is one.
+type Position struct {
+	file         string
+	line, column int
+}
+
+func (p Position) String() string {
+	if p.file != "" {
+		return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.column)
+	}
+	return fmt.Sprintf("%d:%d", p.line, p.column)
+}
+
+func (s *Source) Position() Position {
+	// the line is the last one starting at or before index
+	line, _ := slices.BinarySearch(s.lines, s.index+1)
+	return Position{
+		file:   s.file,
+		line:   line,
+		column: s.index - s.lines[line-1] + 1,
 	}
 }
 
@@ -62,9 +102,22 @@ func Constant[T any](value T) Parser[T] {
 	}}
 }
 
+// Located yields the position of the next token without consuming input.
+func Located() Parser[Position] {
+	return Parser[Position]{func(source *Source) *ParseResult[Position] {
+		return &ParseResult[Position]{value: source.Position(), source: source}
+	}}
+}
+
+// ParseError is what Error stops parsing with. Callers of
+// ParseFileToCompletion recover it to report the error.
+type ParseError struct {
+	error
+}
+
 func Error[T any](message string) Parser[T] {
 	return Parser[T]{func(source *Source) *ParseResult[T] {
-		panic(errors.New(message))
+		panic(ParseError{errors.New(message)})
 	}}
 }
 
@@ -142,7 +195,16 @@ func Maybe[T any](parser Parser[T]) Parser[*T] {
 }
 
 func (p Parser[T]) ParseStringToCompletion(str string) T {
-	source := NewSource(str, 0)
+	return p.parseToCompletion(NewSource(str, 0))
+}
+
+// ParseFileToCompletion parses the contents of a file, whose name then
+// appears in positions.
+func (p Parser[T]) ParseFileToCompletion(file, str string) T {
+	return p.parseToCompletion(&Source{str: str, file: file, lines: lineStarts(str)})
+}
+
+func (p Parser[T]) parseToCompletion(source *Source) T {
 	if p.Parse == nil {
 		panic("Parse error: parser has nil Parse function")
 	}
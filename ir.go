@@ -0,0 +1,699 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// irValue is a virtual register of an irFunction. There are as many as a
+// function needs; a backend decides where each one lives.
+type irValue int
+
+// noValue is the destination of instructions that produce nothing.
+const noValue irValue = -1
+
+func (v irValue) String() string {
+	return fmt.Sprintf("%%%d", int(v))
+}
+
+// irOp is an operation of the three-address IR. Objects are laid out as on
+// ARM, so offsets and sizes are in bytes.
+type irOp int
+
+const (
+	irConstant    irOp = iota // dst = constant
+	irCopy                    // dst = args[0]
+	irParameter               // dst = parameter number constant
+	irAddress                 // dst = address of symbol
+	irAdd                     // dst = args[0] + args[1]
+	irSubtract                // dst = args[0] - args[1]
+	irMultiply                // dst = args[0] * args[1]
+	irDivide                  // dst = args[0] / args[1], or 0 when args[1] is 0
+	irRemainder               // dst = args[0] % args[1], or args[0] when args[1] is 0
+	irEqual                   // dst = 1 if args[0] == args[1], else 0
+	irNotEqual                // dst = 1 if args[0] != args[1], else 0
+	irNegate                  // dst = -args[0]
+	irNot                     // dst = 1 if args[0] is 0, else 0
+	irLoad                    // dst = the word constant bytes past args[0]
+	irStore                   // store args[1] constant bytes past args[0]
+	irAlloc                   // dst = address of constant bytes of zeroed memory
+	irField                   // dst = address of the field with id constant of record args[0]
+	irCall                    // dst = symbol(args...)
+	irCallClosure             // dst = closure args[0] called with args[1:]
+	irPhi                     // dst = args[i] when coming from the block's i-th predecessor
+	irJump                    // continue at targets[0]
+	irBranch                  // continue at targets[0] if args[0] is not 0, else at targets[1]
+	irSwitch                  // continue at targets[i] if args[0] == cases[i], else at the last target
+	irReturn                  // return args[0]
+)
+
+var irBinaryOperators = map[irOp]string{
+	irAdd:       "+",
+	irSubtract:  "-",
+	irMultiply:  "*",
+	irDivide:    "/",
+	irRemainder: "%",
+	irEqual:     "==",
+	irNotEqual:  "!=",
+}
+
+// isTerminator reports whether op ends a basic block.
+func (op irOp) isTerminator() bool {
+	return op >= irJump
+}
+
+// hasSideEffect reports whether an instruction of this kind must stay even
+// when nothing uses its result.
+func (op irOp) hasSideEffect() bool {
+	switch op {
+	case irStore, irAlloc, irField, irCall, irCallClosure:
+		return true
+	}
+	return op.isTerminator()
+}
+
+type irInstruction struct {
+	op       irOp
+	dst      irValue
+	args     []irValue
+	constant int
+	symbol   string
+	targets  []*irBlock
+	cases    []int
+}
+
+// irBlock is a basic block: straight-line instructions ending in exactly
+// one terminator, whose targets are the block's successors.
+type irBlock struct {
+	label        *Label
+	instructions []*irInstruction
+	predecessors []*irBlock
+}
+
+// terminator returns the last instruction of the block if it ends the
+// block, or nil while the block is still open.
+func (b *irBlock) terminator() *irInstruction {
+	if len(b.instructions) == 0 {
+		return nil
+	}
+	if last := b.instructions[len(b.instructions)-1]; last.op.isTerminator() {
+		return last
+	}
+	return nil
+}
+
+func (b *irBlock) successors() []*irBlock {
+	return b.terminator().targets
+}
+
+// irFunction is a function in IR form. The entry is the first block and
+// starts with the parameters. values counts the virtual registers.
+type irFunction struct {
+	name       string
+	parameters int
+	blocks     []*irBlock
+	values     int
+}
+
+func (f *irFunction) value() irValue {
+	f.values++
+	return irValue(f.values - 1)
+}
+
+// irProgram is a program lowered to IR: its functions, the initializer
+// and the closures' code among them, and the data they refer to.
+type irProgram struct {
+	parts     programParts
+	functions []*irFunction
+}
+
+// finish drops the blocks no path from the entry reaches and recomputes
+// the predecessors of the others, keeping the phis of a block in step
+// with them.
+func (f *irFunction) finish() {
+	reached := map[*irBlock]bool{f.blocks[0]: true}
+	work := []*irBlock{f.blocks[0]}
+	for len(work) > 0 {
+		block := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, successor := range block.successors() {
+			if !reached[successor] {
+				reached[successor] = true
+				work = append(work, successor)
+			}
+		}
+	}
+	f.blocks = slices.DeleteFunc(f.blocks, func(block *irBlock) bool { return !reached[block] })
+
+	predecessors := make(map[*irBlock][]*irBlock)
+	for _, block := range f.blocks {
+		for _, successor := range block.successors() {
+			if !slices.Contains(predecessors[successor], block) {
+				predecessors[successor] = append(predecessors[successor], block)
+			}
+		}
+	}
+	for _, block := range f.blocks {
+		old := block.predecessors
+		block.predecessors = predecessors[block]
+		for _, in := range block.instructions {
+			if in.op != irPhi {
+				continue
+			}
+			args := []irValue{}
+			for _, predecessor := range block.predecessors {
+				args = append(args, in.args[slices.Index(old, predecessor)])
+			}
+			in.args = args
+		}
+	}
+}
+
+// lowerProgram lowers a linked, checked program to IR.
+func lowerProgram(node AST, env *Environment) *irProgram {
+	program, ok := node.(Program)
+	if !ok {
+		panic(fmt.Sprintf("cannot compile %T outside of a program", node))
+	}
+	parts := program.split(env)
+	p := &irProgram{parts: parts}
+	p.lower(parts.init(), env, nil)
+	for _, function := range parts.functions {
+		switch function := function.(type) {
+		case Function:
+			p.lower(function, env, nil)
+		case Main:
+			p.lower(Function{name: "main", parameters: []string{}, body: Block{statements: function.statements}}, env, nil)
+		}
+	}
+	return p
+}
+
+// irBuilder lowers one function. Blocks are created when a label is
+// first referred to and placed in the order the labels are placed; code
+// after a terminator goes into a new block, which finish drops.
+type irBuilder struct {
+	program  *irProgram
+	function *irFunction
+	block    *irBlock
+	blocks   map[*Label]*irBlock
+}
+
+func (b *irBuilder) blockOf(label *Label) *irBlock {
+	if block, exists := b.blocks[label]; exists {
+		return block
+	}
+	block := &irBlock{label: label}
+	b.blocks[label] = block
+	return block
+}
+
+// place starts the block of label, falling through to it from the
+// current block.
+func (b *irBuilder) place(label *Label) {
+	block := b.blockOf(label)
+	if b.block.terminator() == nil {
+		b.jump(label)
+	}
+	b.function.blocks = append(b.function.blocks, block)
+	b.block = block
+}
+
+func (b *irBuilder) emit(in *irInstruction) {
+	if b.block.terminator() != nil {
+		b.place(NewLabel())
+	}
+	b.block.instructions = append(b.block.instructions, in)
+}
+
+// operation emits an instruction with a new destination and returns it.
+func (b *irBuilder) operation(op irOp, args ...irValue) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: op, dst: dst, args: args})
+	return dst
+}
+
+func (b *irBuilder) constant(value int) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irConstant, dst: dst, constant: value})
+	return dst
+}
+
+func (b *irBuilder) address(symbol string) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irAddress, dst: dst, symbol: symbol})
+	return dst
+}
+
+func (b *irBuilder) copy(dst, src irValue) {
+	b.emit(&irInstruction{op: irCopy, dst: dst, args: []irValue{src}})
+}
+
+func (b *irBuilder) load(address irValue, offset int) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irLoad, dst: dst, args: []irValue{address}, constant: offset})
+	return dst
+}
+
+func (b *irBuilder) store(address irValue, offset int, value irValue) {
+	b.emit(&irInstruction{op: irStore, dst: noValue, args: []irValue{address, value}, constant: offset})
+}
+
+func (b *irBuilder) alloc(bytes int) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irAlloc, dst: dst, constant: bytes})
+	return dst
+}
+
+func (b *irBuilder) call(symbol string, args ...irValue) irValue {
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irCall, dst: dst, args: args, symbol: symbol})
+	return dst
+}
+
+func (b *irBuilder) jump(label *Label) {
+	b.emit(&irInstruction{op: irJump, dst: noValue, targets: []*irBlock{b.blockOf(label)}})
+}
+
+// branchIfZero continues at label if value is 0 and after it otherwise.
+func (b *irBuilder) branchIfZero(value irValue, label *Label) {
+	next := NewLabel()
+	b.emit(&irInstruction{op: irBranch, dst: noValue, args: []irValue{value}, targets: []*irBlock{b.blockOf(next), b.blockOf(label)}})
+	b.place(next)
+}
+
+// box moves value into a new heap cell and returns the cell.
+func (b *irBuilder) box(value irValue) irValue {
+	cell := b.alloc(4)
+	b.store(cell, 0, value)
+	return cell
+}
+
+// lower lowers a function and adds it to the program. Every variable
+// that is not boxed gets a virtual register of its own, which
+// assignments overwrite; captures numbers the variables in the
+// environment of a closure.
+func (p *irProgram) lower(function Function, outer *Environment, captures map[string]int) {
+	f := &irFunction{name: function.name, parameters: len(function.parameters)}
+	p.functions = append(p.functions, f)
+	b := &irBuilder{program: p, function: f, blocks: make(map[*Label]*irBlock)}
+	b.block = b.blockOf(NewLabel())
+	f.blocks = append(f.blocks, b.block)
+
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(function.body)
+	env.captures = captures
+	for i, param := range function.parameters {
+		value := f.value()
+		b.emit(&irInstruction{op: irParameter, dst: value, constant: i})
+		env.locals[param] = int(value)
+	}
+	for _, param := range function.parameters {
+		if env.boxed[param] {
+			env.locals[param] = int(b.box(irValue(env.locals[param])))
+		}
+	}
+	if function.name == "main" {
+		b.call(initFunction)
+	}
+	b.statement(function.body, env)
+	b.emit(&irInstruction{op: irReturn, dst: noValue, args: []irValue{b.constant(0)}})
+	f.finish()
+}
+
+func (b *irBuilder) statement(node AST, env *Environment) {
+	switch node := node.(type) {
+	case Return:
+		value := b.expression(node.term, env)
+		b.emit(&irInstruction{op: irReturn, dst: noValue, args: []irValue{value}})
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			b.statement(statement, scope)
+		}
+	case If:
+		ifFalse := NewLabel()
+		end := NewLabel()
+		b.branchIfZero(b.expression(node.conditional, env), ifFalse)
+		b.statement(node.consequence, env.scope())
+		b.jump(end)
+		b.place(ifFalse)
+		if node.alternative != nil {
+			b.statement(node.alternative, env.scope())
+		}
+		b.place(end)
+	case While:
+		loopStart := NewLabel()
+		loopEnd := NewLabel()
+		b.place(loopStart)
+		b.branchIfZero(b.expression(node.conditional, env), loopEnd)
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStart})
+		b.statement(node.body, body)
+		b.jump(loopStart)
+		b.place(loopEnd)
+	case For:
+		loopStart := NewLabel()
+		loopStep := NewLabel()
+		loopEnd := NewLabel()
+		// a variable declared in init is visible to the whole loop only
+		scope := env.scope()
+		if node.init != nil {
+			b.statement(node.init, scope)
+		}
+		b.place(loopStart)
+		if node.conditional != nil {
+			b.branchIfZero(b.expression(node.conditional, scope), loopEnd)
+		}
+		body := scope.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStep})
+		b.statement(node.body, body)
+		b.place(loopStep)
+		if node.step != nil {
+			b.statement(node.step, scope)
+		}
+		b.jump(loopStart)
+		b.place(loopEnd)
+	case Switch:
+		plan := node.plan()
+		targets := []*irBlock{}
+		for _, label := range plan.caseLabels {
+			targets = append(targets, b.blockOf(label))
+		}
+		b.emit(&irInstruction{
+			op:      irSwitch,
+			dst:     noValue,
+			args:    []irValue{b.expression(node.value, env)},
+			targets: append(targets, b.blockOf(plan.target)),
+			cases:   plan.values,
+		})
+		scope := plan.scope(env)
+		for i, clause := range node.cases {
+			b.place(plan.labels[i])
+			for _, statement := range clause.statements {
+				b.statement(statement, scope)
+			}
+		}
+		b.place(plan.end)
+	case Break:
+		loop, ok := env.innermostLoop()
+		if !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		b.jump(loop.breakLabel)
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		b.jump(loop.continueLabel)
+	case Assign:
+		b.assign(node.name, b.expression(node.value, env), env)
+	case MemberAssign:
+		// the value may allocate, so the field's address, which the
+		// collector does not take for a root, is computed after it
+		object := b.expression(node.object, env)
+		value := b.expression(node.value, env)
+		base, offset := b.field(object, node.field, node.pos, env)
+		b.store(base, offset, value)
+	case Var:
+		if _, exists := env.locals[node.name]; exists {
+			panic(fmt.Sprintf("Variable already declared in this scope: %s", node.name))
+		}
+		if isRecursive(node) {
+			b.declare(node.name, b.constant(0), env)
+			b.assign(node.name, b.expression(node.value, env), env)
+			return
+		}
+		b.declare(node.name, b.expression(node.value, env), env)
+	case Assert:
+		failed := NewLabel()
+		end := NewLabel()
+		b.branchIfZero(b.operation(irEqual, b.expression(node.condition, env), b.constant(1)), failed)
+		b.call("putchar", b.constant('.'))
+		b.jump(end)
+		b.place(failed)
+		b.call("putchar", b.constant('F'))
+		b.place(end)
+	case Function, Extern, Import, Export:
+		panic(misplaced(node))
+	default:
+		b.expression(node, env)
+	}
+}
+
+// declare binds a variable to a new virtual register holding value, or
+// holding the cell of value if the variable is boxed.
+func (b *irBuilder) declare(name string, value irValue, env *Environment) {
+	if env.boxed[name] {
+		env.locals[name] = int(b.box(value))
+		return
+	}
+	variable := b.function.value()
+	b.copy(variable, value)
+	env.locals[name] = int(variable)
+}
+
+func (b *irBuilder) assign(name string, value irValue, env *Environment) {
+	if variable, exists := env.lookup(name); exists {
+		if env.boxed[name] {
+			b.store(irValue(variable), 0, value)
+		} else {
+			b.copy(irValue(variable), value)
+		}
+	} else if _, exists := env.captures[name]; exists {
+		b.store(b.cell(name, env), 0, value)
+	} else if env.symbols.globals[name] {
+		b.store(b.address(globalSymbol(name)), 0, value)
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+// cell returns the heap cell of a boxed or captured variable.
+func (b *irBuilder) cell(name string, env *Environment) irValue {
+	if variable, exists := env.lookup(name); exists {
+		return irValue(variable)
+	}
+	environment, _ := env.lookup(closureParameter)
+	return b.load(irValue(environment), 4*env.captures[name])
+}
+
+func (b *irBuilder) variable(name string, env *Environment) irValue {
+	if variable, exists := env.lookup(name); exists {
+		if env.boxed[name] {
+			return b.load(irValue(variable), 0)
+		}
+		return irValue(variable)
+	} else if _, exists := env.captures[name]; exists {
+		return b.load(b.cell(name, env), 0)
+	} else if env.symbols.globals[name] {
+		return b.load(b.address(globalSymbol(name)), 0)
+	} else if env.symbols.functions[name] {
+		env.symbols.closures[name] = true
+		return b.address(staticClosure(name))
+	}
+	panic(fmt.Sprintf("Undefined variable: %s", name))
+}
+
+// field returns the base and offset of a field of object.
+func (b *irBuilder) field(object irValue, name string, pos Position, env *Environment) (irValue, int) {
+	if index, known := env.symbols.fields[pos]; known {
+		return object, fieldOffset(index)
+	}
+	dst := b.function.value()
+	b.emit(&irInstruction{op: irField, dst: dst, args: []irValue{object}, constant: env.symbols.fieldId(name)})
+	return dst, 0
+}
+
+// irOperators are the operations of the binary operators.
+var irOperators = map[string]irOp{
+	"Equal":    irEqual,
+	"NotEqual": irNotEqual,
+	"Add":      irAdd,
+	"Subtract": irSubtract,
+	"Multiply": irMultiply,
+	"Divide":   irDivide,
+	"Modulo":   irRemainder,
+}
+
+func (b *irBuilder) binary(left, right AST, operator string, env *Environment) irValue {
+	a := b.expression(left, env)
+	return b.operation(irOperators[operator], a, b.expression(right, env))
+}
+
+// expression lowers node and returns the value it evaluates to. A
+// variable evaluates to its own register, so the result must not be
+// assigned to; as assignments are statements, the register cannot change
+// while the rest of the expression is evaluated.
+func (b *irBuilder) expression(node AST, env *Environment) irValue {
+	switch node := node.(type) {
+	case Number:
+		return b.constant(node.value)
+	case Boolean:
+		if node.value {
+			return b.constant(1)
+		}
+		return b.constant(0)
+	case Id:
+		return b.variable(node.value, env)
+	case Not:
+		return b.operation(irNot, b.expression(node.term, env))
+	case Negate:
+		return b.operation(irNegate, b.expression(node.term, env))
+	case Equal:
+		return b.binary(node.left, node.right, "Equal", env)
+	case NotEqual:
+		return b.binary(node.left, node.right, "NotEqual", env)
+	case Add:
+		return b.binary(node.left, node.right, "Add", env)
+	case Subtract:
+		return b.binary(node.left, node.right, "Subtract", env)
+	case Multiply:
+		return b.binary(node.left, node.right, "Multiply", env)
+	case Divide:
+		return b.binary(node.left, node.right, "Divide", env)
+	case Modulo:
+		return b.binary(node.left, node.right, "Modulo", env)
+	case Call:
+		args := []irValue{}
+		for _, arg := range node.args {
+			args = append(args, b.expression(arg, env))
+		}
+		if env.isVariable(node.callee) {
+			dst := b.function.value()
+			closure := b.variable(node.callee, env)
+			b.emit(&irInstruction{op: irCallClosure, dst: dst, args: append([]irValue{closure}, args...)})
+			return dst
+		}
+		return b.call(node.callee, args...)
+	case FunctionExpression:
+		return b.closure(node, env)
+	case Record:
+		names := layout(node.names())
+		for i := 1; i < len(names); i++ {
+			if names[i] == names[i-1] {
+				panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+			}
+		}
+		record := b.alloc(fieldOffset(len(names)))
+		b.store(record, 0, b.address(env.symbols.shape(names)))
+		for _, field := range node.fields {
+			b.store(record, fieldOffset(slices.Index(names, field.name)), b.expression(field.value, env))
+		}
+		return record
+	case Member:
+		object := b.expression(node.object, env)
+		return b.load(b.field(object, node.field, node.pos, env))
+	default:
+		panic(fmt.Sprintf("%T cannot be used as a value", node))
+	}
+}
+
+// closure lowers a function expression into a function of its own and
+// creates the closure: the code's address and an environment with the
+// cells of the captured variables.
+func (b *irBuilder) closure(fe FunctionExpression, env *Environment) irValue {
+	code := NewLabel()
+	captures := fe.captures(env)
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	b.program.lower(fe.function(code), env, indexes)
+
+	var environment irValue
+	if len(captures) == 0 {
+		environment = b.constant(0)
+	} else {
+		environment = b.alloc(4 * len(captures))
+		for i, name := range captures {
+			b.store(environment, 4*i, b.cell(name, env))
+		}
+	}
+	closure := b.alloc(8)
+	b.store(closure, 0, b.address(code.String()))
+	b.store(closure, 4, environment)
+	return closure
+}
+
+func (in *irInstruction) String() string {
+	var s strings.Builder
+	if in.dst != noValue {
+		fmt.Fprintf(&s, "%s = ", in.dst)
+	}
+	switch in.op {
+	case irConstant:
+		fmt.Fprint(&s, in.constant)
+	case irCopy:
+		fmt.Fprint(&s, in.args[0])
+	case irParameter:
+		fmt.Fprintf(&s, "parameter %d", in.constant)
+	case irAddress:
+		fmt.Fprintf(&s, "address %s", in.symbol)
+	case irAdd, irSubtract, irMultiply, irDivide, irRemainder, irEqual, irNotEqual:
+		fmt.Fprintf(&s, "%s %s %s", in.args[0], irBinaryOperators[in.op], in.args[1])
+	case irNegate:
+		fmt.Fprintf(&s, "-%s", in.args[0])
+	case irNot:
+		fmt.Fprintf(&s, "!%s", in.args[0])
+	case irLoad:
+		fmt.Fprintf(&s, "load [%s + %d]", in.args[0], in.constant)
+	case irStore:
+		fmt.Fprintf(&s, "store [%s + %d], %s", in.args[0], in.constant, in.args[1])
+	case irAlloc:
+		fmt.Fprintf(&s, "alloc %d", in.constant)
+	case irField:
+		fmt.Fprintf(&s, "field %s, %d", in.args[0], in.constant)
+	case irCall:
+		fmt.Fprintf(&s, "call %s(%s)", in.symbol, irValues(in.args))
+	case irCallClosure:
+		fmt.Fprintf(&s, "call %s(%s)", in.args[0], irValues(in.args[1:]))
+	case irPhi:
+		fmt.Fprintf(&s, "phi %s", irValues(in.args))
+	case irJump:
+		fmt.Fprintf(&s, "jump %s", in.targets[0].label)
+	case irBranch:
+		fmt.Fprintf(&s, "branch %s, %s, %s", in.args[0], in.targets[0].label, in.targets[1].label)
+	case irSwitch:
+		fmt.Fprintf(&s, "switch %s", in.args[0])
+		for i, value := range in.cases {
+			fmt.Fprintf(&s, ", %d: %s", value, in.targets[i].label)
+		}
+		fmt.Fprintf(&s, ", default: %s", in.targets[len(in.cases)].label)
+	case irReturn:
+		fmt.Fprintf(&s, "return %s", in.args[0])
+	}
+	return s.String()
+}
+
+func irValues(values []irValue) string {
+	names := []string{}
+	for _, value := range values {
+		names = append(names, value.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// String prints the function with the predecessors of each block.
+func (f *irFunction) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "function %s(%d):\n", f.name, f.parameters)
+	for _, block := range f.blocks {
+		fmt.Fprintf(&s, "%s:", block.label)
+		if len(block.predecessors) > 0 {
+			labels := []string{}
+			for _, predecessor := range block.predecessors {
+				labels = append(labels, predecessor.label.String())
+			}
+			fmt.Fprintf(&s, " ; from %s", strings.Join(labels, ", "))
+		}
+		s.WriteString("\n")
+		for _, in := range block.instructions {
+			fmt.Fprintf(&s, "  %s\n", in)
+		}
+	}
+	return s.String()
+}
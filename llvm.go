@@ -0,0 +1,726 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// llvmGenerator emits an LLVM IR module. Every value is an i32 and every
+// function returns one, 0 when it ends without a return. Each variable
+// lives in an alloca in the entry block of its function, which mem2reg
+// turns into SSA registers, except that captured variables hold the
+// address of a heap cell as on ARM, and top-level variables are globals.
+//
+// As in the C backend, records, closures and cells live in an array of
+// words and are referred to by index, so that they fit in an i32 on any
+// target. Closures hold an index into __baseline_functions, and top-level
+// functions used as values go through an adapter that drops the
+// environment. The heap is never reclaimed.
+type llvmGenerator struct {
+	functions [][]string
+	table     []string
+	arities   map[string]int
+	data      []int
+	closures  map[string]int
+	shapes    map[string]int
+}
+
+// llvmFunction is a function being generated. Its values are named
+// %.N, its labels like those of the ARM backend, and the allocas of its
+// variables %name.addr. terminated is set after a branch or return,
+// until the next label.
+type llvmFunction struct {
+	locals     []string
+	allocas    []string
+	code       []string
+	values     int
+	terminated bool
+}
+
+func (f *llvmFunction) emit(format string, args ...any) {
+	if f.terminated {
+		// code after a return or break is unreachable but needs a block
+		f.label(NewLabel())
+	}
+	f.code = append(f.code, "  "+fmt.Sprintf(format, args...))
+}
+
+// value emits an instruction producing a new value and returns its name.
+func (f *llvmFunction) value(format string, args ...any) string {
+	f.values++
+	name := fmt.Sprintf("%%.%d", f.values)
+	f.emit("%s = %s", name, fmt.Sprintf(format, args...))
+	return name
+}
+
+// label starts a block, falling through from the current one.
+func (f *llvmFunction) label(label *Label) {
+	if !f.terminated {
+		f.code = append(f.code, fmt.Sprintf("  br label %%%s", label))
+	}
+	f.code = append(f.code, label.String()+":")
+	f.terminated = false
+}
+
+// branch ends the current block.
+func (f *llvmFunction) branch(format string, args ...any) {
+	f.emit(format, args...)
+	f.terminated = true
+}
+
+// local adds the alloca of a variable named after name and returns it.
+func (f *llvmFunction) local(name string) string {
+	unique := name
+	for i := 1; slices.Contains(f.locals, unique); i++ {
+		unique = fmt.Sprintf("%s.%d", name, i)
+	}
+	f.locals = append(f.locals, unique)
+	alloca := fmt.Sprintf("%%%s.addr", unique)
+	f.allocas = append(f.allocas, fmt.Sprintf("  %s = alloca i32", alloca))
+	return alloca
+}
+
+// heapAddress returns a pointer to the word at index, reloading the heap,
+// which may have moved.
+func (f *llvmFunction) heapAddress(index string) string {
+	heap := f.value("load ptr, ptr @__baseline_heap")
+	return f.value("getelementptr i32, ptr %s, i32 %s", heap, index)
+}
+
+func (f *llvmFunction) load(index string) string {
+	return f.value("load i32, ptr %s", f.heapAddress(index))
+}
+
+func (f *llvmFunction) store(index, value string) {
+	f.emit("store i32 %s, ptr %s", value, f.heapAddress(index))
+}
+
+// offset returns index plus a constant number of words.
+func (f *llvmFunction) offset(index string, words int) string {
+	if words == 0 {
+		return index
+	}
+	return f.value("add i32 %s, %d", index, words)
+}
+
+func (f *llvmFunction) alloc(words int) string {
+	return f.value("call i32 @%s(i32 %d)", allocFunction, words)
+}
+
+// word allocates words in the initial heap and returns their address.
+func (l *llvmGenerator) word(words ...int) int {
+	address := len(l.data)
+	l.data = append(l.data, words...)
+	return address
+}
+
+func (l *llvmGenerator) shape(names []string, env *Environment) int {
+	names = layout(names)
+	key := strings.Join(names, ",")
+	if address, exists := l.shapes[key]; exists {
+		return address
+	}
+	words := []int{len(names)}
+	for _, name := range names {
+		words = append(words, env.symbols.fieldId(name))
+	}
+	l.shapes[key] = l.word(words...)
+	return l.shapes[key]
+}
+
+func llvmParameters(names []string) string {
+	parameters := []string{}
+	for _, name := range names {
+		parameters = append(parameters, "i32 %"+name)
+	}
+	return strings.Join(parameters, ", ")
+}
+
+// staticClosure returns the address of the closure of a top-level or
+// external function, whose adapter ignores the environment.
+func (l *llvmGenerator) staticClosure(name string) int {
+	if address, exists := l.closures[name]; exists {
+		return address
+	}
+	parameters := []string{}
+	for i := 0; i < l.arities[name]; i++ {
+		parameters = append(parameters, fmt.Sprintf("a%d", i))
+	}
+	l.functions = append(l.functions, []string{
+		fmt.Sprintf("define internal i32 @%s.closure(%s) {", name, llvmParameters(append(slices.Clone(parameters), closureParameter))),
+		fmt.Sprintf("  %%result = call i32 @%s(%s)", name, llvmParameters(parameters)),
+		"  ret i32 %result",
+		"}",
+	})
+	l.table = append(l.table, name+".closure")
+	l.closures[name] = l.word(len(l.table)-1, 0)
+	return l.closures[name]
+}
+
+// emit generates the module of a program.
+func (l *llvmGenerator) emit(node AST, env *Environment) {
+	program, ok := node.(Program)
+	if !ok {
+		panic(fmt.Sprintf("cannot compile %T outside of a program", node))
+	}
+	l.emitProgram(program, env)
+}
+
+func (l *llvmGenerator) emitProgram(p Program, env *Environment) {
+	l.arities = make(map[string]int)
+	l.closures = make(map[string]int)
+	l.shapes = make(map[string]int)
+	// address 0 stays unused so that no object is null
+	l.data = []int{0}
+
+	parts := p.split(env)
+	for _, statement := range p.statements {
+		switch statement := statement.(type) {
+		case Function:
+			l.arities[statement.name] = len(statement.parameters)
+		case Extern:
+			if _, exists := l.arities[statement.name]; !exists {
+				l.arities[statement.name] = len(statement.parameters)
+			}
+		}
+	}
+
+	l.function(initFunction, parts.init(), env, nil)
+	for _, function := range parts.functions {
+		switch function := function.(type) {
+		case Function:
+			l.function(function.name, function, env, nil)
+		case Main:
+			l.function("main", Function{name: "main", parameters: []string{}, body: Block{statements: function.statements}}, env, nil)
+		}
+	}
+
+	if !slices.Contains(parts.externs, "putchar") {
+		// assert prints through putchar
+		emit("declare i32 @putchar(i32)")
+	}
+	for _, name := range parts.externs {
+		emit(fmt.Sprintf("declare i32 @%s(%s)", name, strings.Join(slices.Repeat([]string{"i32"}, l.arities[name]), ", ")))
+	}
+	emit("")
+	for _, global := range parts.initialized {
+		emit(fmt.Sprintf("@%s = internal global i32 %d", global.name, int32(global.value.(Number).value)))
+	}
+	for _, name := range parts.uninitialized {
+		emit(fmt.Sprintf("@%s = internal global i32 0", name))
+	}
+	words := []string{}
+	for _, word := range l.data {
+		words = append(words, fmt.Sprintf("i32 %d", int32(word)))
+	}
+	emit(fmt.Sprintf("@__baseline_data = internal constant [%d x i32] [%s]", len(l.data), strings.Join(words, ", ")))
+	emit(fmt.Sprintf("@__baseline_data_size = internal constant i32 %d", len(l.data)))
+	entries := []string{}
+	for _, function := range l.table {
+		entries = append(entries, "ptr @"+function)
+	}
+	emit(fmt.Sprintf("@__baseline_functions = internal constant [%d x ptr] [%s]", len(entries), strings.Join(entries, ", ")))
+	emit(llvmRuntime)
+	for _, function := range l.functions {
+		emit("")
+		for _, line := range function {
+			emit(line)
+		}
+	}
+}
+
+// function generates a function named name. captures numbers the
+// variables in the environment of a closure.
+func (l *llvmGenerator) function(name string, function Function, outer *Environment, captures map[string]int) {
+	f := &llvmFunction{}
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(function.body)
+	env.captures = captures
+	if name == "main" {
+		f.emit("call void @__baseline_start()")
+		f.emit("call i32 @%s()", initFunction)
+	}
+	for _, param := range function.parameters {
+		if param == closureParameter {
+			// the environment is only read
+			continue
+		}
+		value := "%" + param
+		if env.boxed[param] {
+			value = l.box(value, f)
+		}
+		f.emit("store i32 %s, ptr %s", value, f.local(param))
+		env.locals[param] = len(f.locals) - 1
+	}
+	l.statement(function.body, env, f)
+	if !f.terminated {
+		f.branch("ret i32 0")
+	}
+
+	linkage := ""
+	if captures != nil || name == initFunction {
+		linkage = "internal "
+	}
+	lines := []string{fmt.Sprintf("define %si32 @%s(%s) {", linkage, name, llvmParameters(function.parameters))}
+	lines = append(lines, f.allocas...)
+	lines = append(lines, f.code...)
+	lines = append(lines, "}")
+	l.functions = append(l.functions, lines)
+}
+
+func (l *llvmGenerator) box(value string, f *llvmFunction) string {
+	cell := f.alloc(1)
+	f.store(cell, value)
+	return cell
+}
+
+func (l *llvmGenerator) statement(node AST, env *Environment, f *llvmFunction) {
+	switch node := node.(type) {
+	case Return:
+		f.branch("ret i32 %s", l.expression(node.term, env, f))
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			l.statement(statement, scope, f)
+		}
+	case If:
+		consequence := NewLabel()
+		end := NewLabel()
+		alternative := end
+		if node.alternative != nil {
+			alternative = NewLabel()
+		}
+		f.branch("br i1 %s, label %%%s, label %%%s", l.condition(node.conditional, env, f), consequence, alternative)
+		f.label(consequence)
+		l.statement(node.consequence, env.scope(), f)
+		if node.alternative != nil {
+			f.branch("br label %%%s", end)
+			f.label(alternative)
+			l.statement(node.alternative, env.scope(), f)
+		}
+		f.label(end)
+	case While:
+		loopStart := NewLabel()
+		loopBody := NewLabel()
+		loopEnd := NewLabel()
+		f.label(loopStart)
+		f.branch("br i1 %s, label %%%s, label %%%s", l.condition(node.conditional, env, f), loopBody, loopEnd)
+		f.label(loopBody)
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStart})
+		l.statement(node.body, body, f)
+		f.branch("br label %%%s", loopStart)
+		f.label(loopEnd)
+	case For:
+		loopStart := NewLabel()
+		loopBody := NewLabel()
+		loopStep := NewLabel()
+		loopEnd := NewLabel()
+		// a variable declared in init is visible to the whole loop only
+		scope := env.scope()
+		if node.init != nil {
+			l.statement(node.init, scope, f)
+		}
+		f.label(loopStart)
+		if node.conditional != nil {
+			f.branch("br i1 %s, label %%%s, label %%%s", l.condition(node.conditional, scope, f), loopBody, loopEnd)
+		}
+		f.label(loopBody)
+		body := scope.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStep})
+		l.statement(node.body, body, f)
+		f.label(loopStep)
+		if node.step != nil {
+			l.statement(node.step, scope, f)
+		}
+		f.branch("br label %%%s", loopStart)
+		f.label(loopEnd)
+	case Switch:
+		plan := node.plan()
+		value := l.expression(node.value, env, f)
+		cases := []string{}
+		for i, caseValue := range plan.values {
+			cases = append(cases, fmt.Sprintf("i32 %d, label %%%s", int32(caseValue), plan.caseLabels[i]))
+		}
+		f.branch("switch i32 %s, label %%%s [%s]", value, plan.target, strings.Join(cases, " "))
+		scope := plan.scope(env)
+		for i, clause := range node.cases {
+			f.label(plan.labels[i])
+			for _, statement := range clause.statements {
+				l.statement(statement, scope, f)
+			}
+		}
+		f.label(plan.end)
+	case Break:
+		loop, ok := env.innermostLoop()
+		if !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		f.branch("br label %%%s", loop.breakLabel)
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		f.branch("br label %%%s", loop.continueLabel)
+	case Assign:
+		l.assign(node.name, node.value, env, f)
+	case MemberAssign:
+		object := l.expression(node.object, env, f)
+		value := l.expression(node.value, env, f)
+		f.store(l.field(object, node.field, node.pos, env, f), value)
+	case Var:
+		if _, exists := env.locals[node.name]; exists {
+			panic(fmt.Sprintf("Variable already declared in this scope: %s", node.name))
+		}
+		if isRecursive(node) {
+			l.declare(node.name, "0", env, f)
+			l.assign(node.name, node.value, env, f)
+			return
+		}
+		l.declare(node.name, l.expression(node.value, env, f), env, f)
+	case Assert:
+		passed := f.value("icmp eq i32 %s, 1", l.expression(node.condition, env, f))
+		character := f.value("select i1 %s, i32 %d, i32 %d", passed, '.', 'F')
+		f.value("call i32 @putchar(i32 %s)", character)
+	case Function, Extern, Import, Export:
+		panic(misplaced(node))
+	default:
+		l.expression(node, env, f)
+	}
+}
+
+// condition returns an i1 that is true when node is not zero.
+func (l *llvmGenerator) condition(node AST, env *Environment, f *llvmFunction) string {
+	switch node := node.(type) {
+	case Equal:
+		return f.value("icmp eq i32 %s, %s", l.expression(node.left, env, f), l.expression(node.right, env, f))
+	case NotEqual:
+		return f.value("icmp ne i32 %s, %s", l.expression(node.left, env, f), l.expression(node.right, env, f))
+	}
+	return f.value("icmp ne i32 %s, 0", l.expression(node, env, f))
+}
+
+// declare binds a variable to a new alloca holding value.
+func (l *llvmGenerator) declare(name, value string, env *Environment, f *llvmFunction) {
+	if env.boxed[name] {
+		value = l.box(value, f)
+	}
+	f.emit("store i32 %s, ptr %s", value, f.local(name))
+	env.locals[name] = len(f.locals) - 1
+}
+
+func (l *llvmGenerator) assign(name string, value AST, env *Environment, f *llvmFunction) {
+	if index, exists := env.lookup(name); exists {
+		alloca := fmt.Sprintf("%%%s.addr", f.locals[index])
+		result := l.expression(value, env, f)
+		if env.boxed[name] {
+			f.store(f.value("load i32, ptr %s", alloca), result)
+			return
+		}
+		f.emit("store i32 %s, ptr %s", result, alloca)
+	} else if _, exists := env.captures[name]; exists {
+		result := l.expression(value, env, f)
+		f.store(l.cell(name, env, f), result)
+	} else if env.symbols.globals[name] {
+		f.emit("store i32 %s, ptr @%s", l.expression(value, env, f), name)
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+// cell returns the address of the heap cell of a boxed or captured
+// variable.
+func (l *llvmGenerator) cell(name string, env *Environment, f *llvmFunction) string {
+	if index, exists := env.lookup(name); exists {
+		return f.value("load i32, ptr %%%s.addr", f.locals[index])
+	}
+	return f.load(f.offset("%"+closureParameter, env.captures[name]))
+}
+
+func (l *llvmGenerator) variable(name string, env *Environment, f *llvmFunction) string {
+	if index, exists := env.lookup(name); exists {
+		value := f.value("load i32, ptr %%%s.addr", f.locals[index])
+		if env.boxed[name] {
+			return f.load(value)
+		}
+		return value
+	} else if _, exists := env.captures[name]; exists {
+		return f.load(l.cell(name, env, f))
+	} else if env.symbols.globals[name] {
+		return f.value("load i32, ptr @%s", name)
+	} else if env.symbols.functions[name] {
+		return fmt.Sprintf("%d", l.staticClosure(name))
+	}
+	panic(fmt.Sprintf("Undefined variable: %s", name))
+}
+
+// field returns the address of a field of the record at object.
+func (l *llvmGenerator) field(object, name string, pos Position, env *Environment, f *llvmFunction) string {
+	if index, known := env.symbols.fields[pos]; known {
+		return f.offset(object, index+1)
+	}
+	return f.value("call i32 @%s(i32 %s, i32 %d)", fieldFunction, object, env.symbols.fieldId(name))
+}
+
+// llvmInstructions are the instructions of the binary operators; division
+// goes through the runtime, which gives 0 rather than undefined behavior
+// when dividing by zero, as on ARM.
+var llvmInstructions = map[string]string{
+	"Equal":    "icmp eq i32",
+	"NotEqual": "icmp ne i32",
+	"Add":      "add i32",
+	"Subtract": "sub i32",
+	"Multiply": "mul i32",
+	"Divide":   "call i32 @__baseline_divide",
+	"Modulo":   "call i32 @__baseline_remainder",
+}
+
+func (l *llvmGenerator) binary(left, right AST, operator string, env *Environment, f *llvmFunction) string {
+	a := l.expression(left, env, f)
+	b := l.expression(right, env, f)
+	instruction := llvmInstructions[operator]
+	switch operator {
+	case "Equal", "NotEqual":
+		return f.value("zext i1 %s to i32", f.value("%s %s, %s", instruction, a, b))
+	case "Divide", "Modulo":
+		return f.value("%s(i32 %s, i32 %s)", instruction, a, b)
+	}
+	return f.value("%s %s, %s", instruction, a, b)
+}
+
+func (l *llvmGenerator) expression(node AST, env *Environment, f *llvmFunction) string {
+	switch node := node.(type) {
+	case Number:
+		return fmt.Sprintf("%d", int32(node.value))
+	case Boolean:
+		if node.value {
+			return "1"
+		}
+		return "0"
+	case Id:
+		return l.variable(node.value, env, f)
+	case Not:
+		return f.value("zext i1 %s to i32", f.value("icmp eq i32 %s, 0", l.expression(node.term, env, f)))
+	case Negate:
+		return f.value("sub i32 0, %s", l.expression(node.term, env, f))
+	case Equal:
+		return l.binary(node.left, node.right, "Equal", env, f)
+	case NotEqual:
+		return l.binary(node.left, node.right, "NotEqual", env, f)
+	case Add:
+		return l.binary(node.left, node.right, "Add", env, f)
+	case Subtract:
+		return l.binary(node.left, node.right, "Subtract", env, f)
+	case Multiply:
+		return l.binary(node.left, node.right, "Multiply", env, f)
+	case Divide:
+		return l.binary(node.left, node.right, "Divide", env, f)
+	case Modulo:
+		return l.binary(node.left, node.right, "Modulo", env, f)
+	case Call:
+		arguments := []string{}
+		for _, arg := range node.args {
+			arguments = append(arguments, "i32 "+l.expression(arg, env, f))
+		}
+		if !env.isVariable(node.callee) {
+			return f.value("call i32 @%s(%s)", node.callee, strings.Join(arguments, ", "))
+		}
+		// the closure's environment is passed after the declared arguments
+		closure := l.variable(node.callee, env, f)
+		index := f.load(closure)
+		code := f.value("load ptr, ptr %s", f.value("getelementptr ptr, ptr @__baseline_functions, i32 %s", index))
+		arguments = append(arguments, "i32 "+f.load(f.offset(closure, 1)))
+		return f.value("call i32 %s(%s)", code, strings.Join(arguments, ", "))
+	case FunctionExpression:
+		return l.closure(node, env, f)
+	case Record:
+		names := layout(node.names())
+		for i := 1; i < len(names); i++ {
+			if names[i] == names[i-1] {
+				panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+			}
+		}
+		record := f.alloc(len(names) + 1)
+		f.store(record, fmt.Sprintf("%d", l.shape(names, env)))
+		for _, field := range node.fields {
+			value := l.expression(field.value, env, f)
+			f.store(f.offset(record, slices.Index(names, field.name)+1), value)
+		}
+		return record
+	case Member:
+		object := l.expression(node.object, env, f)
+		return f.load(l.field(object, node.field, node.pos, env, f))
+	default:
+		panic(fmt.Sprintf("%T cannot be used as a value", node))
+	}
+}
+
+// closure generates the code of a function expression as a function of
+// its own and allocates the closure: its index in __baseline_functions
+// followed by the environment, an array of the cells of the captured
+// variables.
+func (l *llvmGenerator) closure(fe FunctionExpression, env *Environment, f *llvmFunction) string {
+	code := NewLabel()
+	captures := fe.captures(env)
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	l.table = append(l.table, code.String())
+	index := len(l.table) - 1
+	l.function(code.String(), fe.function(code), env, indexes)
+
+	environment := "0"
+	if len(captures) > 0 {
+		environment = f.alloc(len(captures))
+		for i, name := range captures {
+			f.store(f.offset(environment, i), l.cell(name, env, f))
+		}
+	}
+	closure := f.alloc(2)
+	f.store(closure, fmt.Sprintf("%d", index))
+	f.store(f.offset(closure, 1), environment)
+	return closure
+}
+
+// llvmRuntime copies the initial heap to memory from realloc and
+// allocates from its end, doubling it as needed. It provides the field
+// lookup for records and the division helpers.
+const llvmRuntime = `
+@__baseline_heap = internal global ptr null
+@__baseline_heap_size = internal global i32 0
+@__baseline_heap_top = internal global i32 0
+
+declare ptr @realloc(ptr, i64)
+declare void @abort()
+
+define internal void @__baseline_start() {
+entry:
+  %words = load i32, ptr @__baseline_data_size
+  %size = add i32 %words, 65536
+  %size64 = zext i32 %size to i64
+  %bytes = mul i64 %size64, 4
+  %heap = call ptr @realloc(ptr null, i64 %bytes)
+  %failed = icmp eq ptr %heap, null
+  br i1 %failed, label %abort, label %copy
+abort:
+  call void @abort()
+  unreachable
+copy:
+  store ptr %heap, ptr @__baseline_heap
+  store i32 %size, ptr @__baseline_heap_size
+  store i32 %words, ptr @__baseline_heap_top
+  br label %loop
+loop:
+  %i = phi i32 [0, %copy], [%next, %body]
+  %more = icmp slt i32 %i, %words
+  br i1 %more, label %body, label %done
+body:
+  %from = getelementptr i32, ptr @__baseline_data, i32 %i
+  %word = load i32, ptr %from
+  %to = getelementptr i32, ptr %heap, i32 %i
+  store i32 %word, ptr %to
+  %next = add i32 %i, 1
+  br label %loop
+done:
+  ret void
+}
+
+define internal i32 @__baseline_alloc(i32 %words) {
+entry:
+  %top = load i32, ptr @__baseline_heap_top
+  %size = load i32, ptr @__baseline_heap_size
+  %free = sub i32 %size, %top
+  %fits = icmp sle i32 %words, %free
+  br i1 %fits, label %done, label %grow
+grow:
+  %large = icmp sgt i32 %size, 536870911
+  br i1 %large, label %abort, label %double
+double:
+  %doubled = shl i32 %size, 1
+  %needed = add i32 %top, %words
+  %enough = icmp sge i32 %doubled, %needed
+  %new.size = select i1 %enough, i32 %doubled, i32 %needed
+  %heap = load ptr, ptr @__baseline_heap
+  %new.size64 = zext i32 %new.size to i64
+  %bytes = mul i64 %new.size64, 4
+  %new.heap = call ptr @realloc(ptr %heap, i64 %bytes)
+  %failed = icmp eq ptr %new.heap, null
+  br i1 %failed, label %abort, label %grown
+abort:
+  call void @abort()
+  unreachable
+grown:
+  store ptr %new.heap, ptr @__baseline_heap
+  store i32 %new.size, ptr @__baseline_heap_size
+  br label %done
+done:
+  %end = add i32 %top, %words
+  store i32 %end, ptr @__baseline_heap_top
+  ret i32 %top
+}
+
+define internal i32 @__baseline_field(i32 %record, i32 %id) {
+entry:
+  %heap = load ptr, ptr @__baseline_heap
+  %record.address = getelementptr i32, ptr %heap, i32 %record
+  %shape = load i32, ptr %record.address
+  %shape.address = getelementptr i32, ptr %heap, i32 %shape
+  %count = load i32, ptr %shape.address
+  br label %loop
+loop:
+  %i = phi i32 [1, %entry], [%next, %mismatch]
+  %more = icmp sle i32 %i, %count
+  br i1 %more, label %compare, label %missing
+compare:
+  %index = add i32 %shape, %i
+  %id.address = getelementptr i32, ptr %heap, i32 %index
+  %field.id = load i32, ptr %id.address
+  %found = icmp eq i32 %field.id, %id
+  br i1 %found, label %done, label %mismatch
+mismatch:
+  %next = add i32 %i, 1
+  br label %loop
+missing:
+  call void @abort()
+  unreachable
+done:
+  %field = add i32 %record, %i
+  ret i32 %field
+}
+
+define internal i32 @__baseline_divide(i32 %dividend, i32 %divisor) {
+entry:
+  %zero = icmp eq i32 %divisor, 0
+  br i1 %zero, label %by.zero, label %nonzero
+by.zero:
+  ret i32 0
+nonzero:
+  %minus.one = icmp eq i32 %divisor, -1
+  br i1 %minus.one, label %negate, label %divide
+negate:
+  %negated = sub i32 0, %dividend
+  ret i32 %negated
+divide:
+  %quotient = sdiv i32 %dividend, %divisor
+  ret i32 %quotient
+}
+
+define internal i32 @__baseline_remainder(i32 %dividend, i32 %divisor) {
+entry:
+  %zero = icmp eq i32 %divisor, 0
+  br i1 %zero, label %by.zero, label %nonzero
+by.zero:
+  ret i32 %dividend
+nonzero:
+  %minus.one = icmp eq i32 %divisor, -1
+  br i1 %minus.one, label %by.minus.one, label %divide
+by.minus.one:
+  ret i32 0
+divide:
+  %remainder = srem i32 %dividend, %divisor
+  ret i32 %remainder
+}`
@@ -1,11 +1,47 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
+	softDivide := flag.Bool("soft-div", false, "call __aeabi_idivmod instead of emitting sdiv")
+	printTypes := flag.Bool("print-types", false, "print the inferred signatures instead of assembly")
+	target := flag.String("target", "arm", "the target to emit code for: arm, aarch64, riscv32, wasm, c, llvm or bytecode")
+	output := flag.String("o", "", "the .bbc file to write bytecode to, by default named after the source")
+	run := flag.Bool("run", false, "run the program, or a .bbc file, in the bytecode virtual machine")
+	useIR := flag.Bool("ir", true, "emit ARM code from the intermediate representation; -ir=false walks the AST instead")
+	optimize := flag.Bool("O", false, "optimize the intermediate representation in SSA form")
+	dumpIR := flag.Bool("dump-ir", false, "print the intermediate representation instead of assembly")
+	flag.Parse()
+	hardwareDivide = !*softDivide
+	machine, known := targets[*target]
+	translate, translates := translators[*target]
+	if !known && !translates && *target != "bytecode" {
+		fmt.Fprintf(os.Stderr, "unknown target %s\n", *target)
+		os.Exit(2)
+	}
+	if *optimize && (*target != "arm" || !*useIR) {
+		fmt.Fprintln(os.Stderr, "-O only applies to the intermediate representation of the arm target")
+		os.Exit(2)
+	}
+	*useIR = *useIR && *target == "arm"
+	if *run && filepath.Ext(flag.Arg(0)) == ".bbc" {
+		module, err := loadBytecode(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		runBytecode(module)
+	}
+
 	source := `
+ extern function putchar(c: number): number;
+
  function main() {
       // Test Number
       assert(1);
@@ -14,7 +50,7 @@ func main() {
       assert(!0);
       assert(!(!1));
 
-      putchar(46);
+      putchar('.');
 
       // Test Equal
       assert(42 == 42);
@@ -27,6 +63,20 @@ func main() {
       // Test infix operators
       assert(42 == 4 + 2 * (12 - 2) + 3 * (5 + 1));
 
+      // Test globals and top-level statements
+      assert(answer == 42);
+      assert(counter == 10);
+      assert(bump() == 11);
+      assert(counter == 11);
+
+      // Test unary minus, signed division and modulo
+      assert(-(3 - 5) == 2);
+      assert(-7 / 2 == -3);
+      assert(-7 % 2 == -1);
+      assert(7 % -2 == 1);
+      assert(7 / 0 == 0);
+      assert(7 % 0 == 7);
+
       // Test Call with no parameters
       assert(return42() == 42);
       assert(!returnNothing());
@@ -34,6 +84,8 @@ func main() {
       // Test multiple parameters
       assert42(42);
       assert1234(1, 2, 3, 4);
+      assert(sum5(1, 2, 3, 4, 5) == 15);
+      assert(sum8(1, 2, 3, 4, 5, 6, 7, sum5(1, 1, 1, 1, 4)) == 36);
 
       //assert(rand() != 42);
       //assert(putchar() != 1);
@@ -54,6 +106,16 @@ func main() {
         assert(1);
       }
 
+      // Test If without else and else-if chains
+      if (0)
+        assert(0);
+      if (1) {
+        assert(1);
+      }
+      assert(classify(0) == 10);
+      assert(classify(1) == 11);
+      assert(classify(7) == 12);
+
       assert(factorial(5) == 120);
 
       var x = 4 + 2 * (12 - 2);
@@ -75,7 +137,217 @@ func main() {
 
       assert(factorial2(5) == 120);
 
-      putchar(10); // Newline
+      // Test for loops, break and continue
+      var sum = 0;
+      for (var j = 0; j != 10; j = j + 1) {
+        if (j % 2 == 1)
+          continue;
+        if (j == 8)
+          break;
+        sum = sum + j;
+      }
+      assert(sum == 12);
+
+      var k = 0;
+      for (;;) {
+        k = k + 1;
+        while (1) {
+          break;
+        }
+        if (k == 5)
+          break;
+      }
+      assert(k == 5);
+
+      // Test block scoping and shadowing
+      var shadow = 1;
+      {
+        var shadow = shadow + 1;
+        assert(shadow == 2);
+      }
+      assert(shadow == 1);
+      var n = 0;
+      while (n != 100) {
+        var step = 1;
+        n = n + step;
+      }
+      assert(n == 100);
+
+      // Test first-class functions and closures
+      var next = makeCounter();
+      next();
+      assert(next() == 2);
+      var add5 = makeAdder(5);
+      assert(add5(10) == 15);
+      assert(apply(add5, 1) == 6);
+      assert(twice(makeAdder(3), 1) == 7);
+      var answerFunction = return42;
+      assert(answerFunction() == 42);
+      var total = 0;
+      var addToTotal = function (value) { total = total + value; };
+      addToTotal(5);
+      addToTotal(6);
+      assert(total == 11);
+      function countdown(n) {
+        if (n == 0)
+          return total;
+        return countdown(n - 1);
+      }
+      assert(countdown(3) == 11);
+
+      // Test type annotations
+      var flag: boolean = true;
+      assert(flag);
+      assert(!false);
+      assert(scale(3, true) == 6);
+      var scaled: number = scale(5, false);
+      assert(scaled == 5);
+
+      // Test records
+      var point = {x: 1, y: 2};
+      assert(point.x == 1);
+      assert(point.y == 2);
+      point.x = 10;
+      assert(point.x + point.y == 12);
+      assert(getX({y: 4, x: 3}) == 3);
+      assert(getX(point) == 10);
+      var segment: {from: {x: number, y: number}, to: {x: number, y: number}} =
+        {from: point, to: {x: 5, y: 6}};
+      assert(segment.to.y == 6);
+      segment.from.y = 7;
+      assert(point.y == 7);
+      assert(length(segment) == 5);
+
+      // Test compound assignment
+      var m = 10;
+      m += 5;
+      m -= 3;
+      m *= 4;
+      m /= 6;
+      m %= 5;
+      assert(m == 3);
+      m++;
+      ++m;
+      --m;
+      assert(m == 4);
+      point.x += 5;
+      point.y--;
+      assert(point.x == 15);
+      assert(point.y == 6);
+      segment.to.x *= 2;
+      ++segment.from.x;
+      assert(segment.to.x == 10);
+      assert(point.x == 16);
+
+      // Test switch
+      assert(daysIn(2) == 28);
+      assert(daysIn(4) == 30);
+      assert(daysIn(12) == 31);
+      assert(daysIn(13) == 0);
+      assert(daysIn(-1) == 0);
+      assert(weight(10) == 1);
+      assert(weight(1000) == 3);
+      assert(weight(7) == 0);
+      var visited = 0;
+      for (var state = 0; state != 6; state++) {
+        switch (state) {
+          case 1:
+            continue;
+          case 3:
+          case 4:
+            visited += 10;
+            break;
+          default:
+            visited++;
+        }
+        visited += 100;
+      }
+      assert(visited == 523);
+
+      // Test literals
+      assert('a' == 97);
+      assert('\n' == 10);
+      assert('\'' == 39);
+      assert(0x2A == 42);
+      assert(0b101010 == 42);
+      assert(0o52 == 42);
+      assert(1_000_000 == 1000 * 1000);
+      assert(0xffff_ffff == -1);
+      assert(-2147483648 == 0x8000_0000);
+
+      // Test garbage collection: the loop allocates several times the heap
+      var kept = makeAdder(1);
+      for (var round = 0; round != 100000; round++) {
+        var discarded = makeAdder(round);
+        discarded(round);
+      }
+      assert(kept(1) == 2);
+
+      putchar('\n');
+    }
+
+    var counter = 0;
+    var answer = 6 * 7;
+    counter = counter + 10;
+
+    function bump() {
+      counter = counter + 1;
+      return counter;
+    }
+
+    function makeCounter() {
+      var count = 0;
+      return function () {
+        count = count + 1;
+        return count;
+      };
+    }
+    function makeAdder(x) {
+      return function (y) { return x + y; };
+    }
+    function apply(f, x) { return f(x); }
+    function twice(f, x) { return f(f(x)); }
+
+    function daysIn(month) {
+      var days = 31;
+      switch (month) {
+        case 2:
+          return 28;
+        case 4:
+        case 6:
+        case 9:
+        case 11:
+          days = 30;
+        case 1:
+        case 3:
+        case 5:
+        case 7:
+        case 8:
+        case 10:
+        case 12:
+          break;
+        default:
+          days = 0;
+      }
+      return days;
+    }
+
+    function weight(n) {
+      switch (n) {
+        case 10: return 1;
+        case 100: return 2;
+        case 1000: return 3;
+      }
+      return 0;
+    }
+
+    function getX(p) { return p.x; }
+    function length(s) { return s.from.x - s.to.x; }
+
+    function scale(x: number, double: boolean): number {
+      if (double)
+        return x * 2;
+      return x;
     }
 
     function return42() { return 42; }
@@ -89,15 +361,30 @@ func main() {
       assert(c == 3);
       assert(d == 4);
     }
+    function sum5(a, b, c, d, e) {
+      return a + b + c + d + e;
+    }
+    function sum8(a, b, c, d, e, f, g, h) {
+      assert(h == 8);
+      return a + b + c + d + e + f + g + h;
+    }
 
     function assert(x) {
       if (x) {
-	putchar(46);
+	putchar('.');
       } else {
-	putchar(70);
+	putchar('F');
       }
     }
 
+    function classify(n) {
+      if (n == 0)
+        return 10;
+      else if (n == 1)
+        return 11;
+      return 12;
+    }
+
     function factorial(n) {
       if (n == 0) {
         return 1;
@@ -109,17 +396,84 @@ func main() {
     function factorial2(n) {
       var result = 1;
       while (n != 1) {
-        result = result * n;
-	n = n - 1;
+        result *= n;
+        n--;
       }
       return result;
     }
       `
 
-	result := parser.ParseStringToCompletion(source)
+	var result AST
+	var err error
+	if flag.NArg() > 0 {
+		result, err = LoadProgram(flag.Arg(0))
+	} else {
+		result, err = LinkProgram("", source)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	fmt.Printf("Parse successful: %#v\n", result)
 
-	result.Emit(NewEnvironment())
+	typing, errors := CheckTypes(result)
+	if len(errors) > 0 {
+		for _, err := range errors {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	if *printTypes {
+		for _, signature := range typing.signatures {
+			fmt.Println(signature)
+		}
+		return
+	}
+
+	env := NewEnvironment()
+	env.symbols.fields = typing.fields
+	lower := func() *irProgram {
+		program := lowerProgram(result, env)
+		if *optimize {
+			program.optimize()
+		}
+		return program
+	}
+	if *dumpIR {
+		for _, function := range lower().functions {
+			fmt.Print(function)
+		}
+		return
+	}
+	if *run {
+		runBytecode(compileBytecode(result, env))
+	}
+	if *target == "bytecode" {
+		path := *output
+		if path == "" {
+			path = "main.bbc"
+			if flag.NArg() > 0 {
+				path = strings.TrimSuffix(flag.Arg(0), filepath.Ext(flag.Arg(0))) + ".bbc"
+			}
+		}
+		data, err := compileBytecode(result, env).MarshalBinary()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *useIR {
+		lower().emitARM(env)
+	} else if machine != nil {
+		generator{machine: machine}.emit(result, env)
+	} else {
+		translate().emit(result, env)
+	}
 
 	fmt.Println("All tests passed! Compiler rewritten in Go successfully!")
 }
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sourceExtension is appended to an import path that names no file.
+const sourceExtension = ".bl"
+
+// Import brings exported declarations of another module into scope, as in
+// import { f, g } from "./lib". The path is relative to the importing
+// file.
+type Import struct {
+	names []string
+	path  string
+	pos   Position
+}
+
+func (i Import) Equals(other AST) bool {
+	if otherImport, ok := other.(*Import); ok {
+		return i.path == otherImport.path && slices.Equal(i.names, otherImport.names)
+	}
+	return false
+}
+
+// Export makes a top-level function or variable importable.
+type Export struct {
+	declaration AST
+	pos         Position
+}
+
+func (e Export) Equals(other AST) bool {
+	if otherExport, ok := other.(*Export); ok {
+		return e.declaration.Equals(otherExport.declaration)
+	}
+	return false
+}
+
+// LoadProgram reads the module at path and everything it imports, and
+// links them into one program.
+func LoadProgram(path string) (AST, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LinkProgram(path, string(source))
+}
+
+// LinkProgram links an entry module and the modules it imports, found on
+// the file system relative to path, into one program. An empty path
+// stands for a source string, whose imports are relative to the working
+// directory.
+//
+// The top-level names of the entry module are kept; those of every other
+// module are prefixed with the module's path, as in util.math.square,
+// which no identifier can clash with. Modules come before the modules
+// importing them, so their top-level statements run first.
+func LinkProgram(path, source string) (AST, error) {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	l := &linker{root: filepath.Dir(path), modules: make(map[string]*module)}
+	if _, err := l.link(path, source); err != nil {
+		return nil, err
+	}
+	return Program{statements: l.statements}, nil
+}
+
+type module struct {
+	// prefix is prepended to the module's top-level names
+	prefix  string
+	exports map[string]bool
+}
+
+func (m *module) mangle(name string) string {
+	return m.prefix + name
+}
+
+type linker struct {
+	root       string
+	modules    map[string]*module
+	loading    []string
+	statements []AST
+}
+
+// load links the module at path unless it already has been. pos is the
+// import that needs it.
+func (l *linker) load(path string, pos Position) (*module, error) {
+	if _, err := os.Stat(path); err != nil && filepath.Ext(path) == "" {
+		path += sourceExtension
+	}
+	path = filepath.Clean(path)
+	if m, exists := l.modules[path]; exists {
+		return m, nil
+	}
+	if start := slices.Index(l.loading, path); start >= 0 {
+		cycle := append(slices.Clone(l.loading[start:]), path)
+		return nil, fmt.Errorf("%s: import cycle: %s", pos, strings.Join(cycle, " -> "))
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pos, err)
+	}
+	return l.link(path, string(source))
+}
+
+// parseModule parses a module, returning the errors the parser reports
+// with Error.
+func parseModule(path, source string) (program Program, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			parseError, ok := recovered.(ParseError)
+			if !ok {
+				panic(recovered)
+			}
+			err = parseError.error
+		}
+	}()
+	return parser.ParseFileToCompletion(path, source).(Program), nil
+}
+
+func (l *linker) link(path, source string) (*module, error) {
+	l.loading = append(l.loading, path)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	program, err := parseModule(path, source)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &module{exports: make(map[string]bool)}
+	if len(l.loading) > 1 {
+		m.prefix = modulePrefix(l.root, path)
+	}
+
+	// top-level names refer to this module's declarations or to imports
+	names := make(map[string]string)
+	statements := []AST{}
+	for _, statement := range program.statements {
+		if export, ok := statement.(Export); ok {
+			statement = export.declaration
+			m.exports[declaredName(statement)] = true
+		}
+		if name := declaredName(statement); name != "" {
+			names[name] = m.mangle(name)
+		}
+		statements = append(statements, statement)
+	}
+
+	linked := []AST{}
+	for _, statement := range statements {
+		imported, ok := statement.(Import)
+		if !ok {
+			linked = append(linked, statement)
+			continue
+		}
+		dependency, err := l.load(filepath.Join(filepath.Dir(path), imported.path), imported.pos)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range imported.names {
+			if !dependency.exports[name] {
+				return nil, fmt.Errorf("%s: %s does not export %s", imported.pos, imported.path, name)
+			}
+			if _, declared := names[name]; declared {
+				return nil, fmt.Errorf("%s: %s is already declared", imported.pos, name)
+			}
+			names[name] = dependency.mangle(name)
+		}
+	}
+
+	r := renamer{names: names}
+	for _, statement := range linked {
+		renamed, err := r.topLevel(statement)
+		if err != nil {
+			return nil, err
+		}
+		l.statements = append(l.statements, renamed)
+	}
+	l.modules[path] = m
+	return m, nil
+}
+
+func declaredName(statement AST) string {
+	switch statement := statement.(type) {
+	case Function:
+		return statement.name
+	case Var:
+		return statement.name
+	default:
+		return ""
+	}
+}
+
+// modulePrefix derives a module's prefix from its path relative to the
+// entry module, turning directories into dot-separated parts.
+func modulePrefix(root, path string) string {
+	relative, err := filepath.Rel(root, path)
+	if err != nil {
+		relative = path
+	}
+	relative = strings.TrimSuffix(relative, filepath.Ext(relative))
+	parts := []string{}
+	for _, part := range strings.Split(filepath.ToSlash(relative), "/") {
+		part = strings.Map(func(r rune) rune {
+			if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, part)
+		if part == "" || part[0] >= '0' && part[0] <= '9' {
+			part = "_" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ".") + "."
+}
+
+// renamer replaces the top-level names of a module with their linked
+// names, except where a local declaration shadows them.
+type renamer struct {
+	names map[string]string
+}
+
+func (r renamer) name(name string) string {
+	if renamed, exists := r.names[name]; exists {
+		return renamed
+	}
+	return name
+}
+
+// shadow returns a renamer that leaves the given names alone.
+func (r renamer) shadow(names ...string) renamer {
+	shadowed := renamer{names: maps.Clone(r.names)}
+	for _, name := range names {
+		delete(shadowed.names, name)
+	}
+	return shadowed
+}
+
+func (r renamer) topLevel(statement AST) (result AST, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			message, ok := recovered.(string)
+			if !ok {
+				panic(recovered)
+			}
+			err = errors.New(message)
+		}
+	}()
+	switch statement := statement.(type) {
+	case Function:
+		statement.name = r.name(statement.name)
+		statement.body = r.shadow(statement.parameters...).rename(statement.body)
+		return statement, nil
+	case Var:
+		statement.name = r.name(statement.name)
+		statement.value = r.rename(statement.value)
+		return statement, nil
+	default:
+		return r.rename(statement), nil
+	}
+}
+
+func (r renamer) rename(node AST) AST {
+	switch node := node.(type) {
+	case nil, Number, Boolean, Break, Continue, Extern:
+		// externs keep their names, which belong to the C library
+		return node
+	case Id:
+		node.value = r.name(node.value)
+		return node
+	case Not:
+		return Not{term: r.rename(node.term)}
+	case Negate:
+		return Negate{term: r.rename(node.term)}
+	case Equal:
+		return Equal{left: r.rename(node.left), right: r.rename(node.right)}
+	case NotEqual:
+		return NotEqual{left: r.rename(node.left), right: r.rename(node.right)}
+	case Add:
+		return Add{left: r.rename(node.left), right: r.rename(node.right)}
+	case Subtract:
+		return Subtract{left: r.rename(node.left), right: r.rename(node.right)}
+	case Multiply:
+		return Multiply{left: r.rename(node.left), right: r.rename(node.right)}
+	case Divide:
+		return Divide{left: r.rename(node.left), right: r.rename(node.right)}
+	case Modulo:
+		return Modulo{left: r.rename(node.left), right: r.rename(node.right)}
+	case Call:
+		node.callee = r.name(node.callee)
+		node.args = r.renameAll(node.args)
+		return node
+	case Return:
+		node.term = r.rename(node.term)
+		return node
+	case Assert:
+		return Assert{condition: r.rename(node.condition)}
+	case Assign:
+		node.name = r.name(node.name)
+		node.value = r.rename(node.value)
+		return node
+	case Block:
+		return Block{statements: r.renameStatements(node.statements)}
+	case Main:
+		return Main{statements: r.renameStatements(node.statements)}
+	case If:
+		return If{
+			conditional: r.rename(node.conditional),
+			consequence: r.rename(node.consequence),
+			alternative: r.rename(node.alternative),
+		}
+	case While:
+		return While{conditional: r.rename(node.conditional), body: r.rename(node.body)}
+	case Switch:
+		// the clauses share a scope
+		value := r.rename(node.value)
+		cases := []switchCase{}
+		for _, clause := range node.cases {
+			clause.statements = r.renameStatements(clause.statements)
+			for _, statement := range clause.statements {
+				if declaration, ok := statement.(Var); ok {
+					r = r.shadow(declaration.name)
+				}
+			}
+			cases = append(cases, clause)
+		}
+		return Switch{value: value, cases: cases, pos: node.pos}
+	case For:
+		inner := r
+		if init, ok := node.init.(Var); ok {
+			inner = r.shadow(init.name)
+		}
+		return For{
+			init:        r.rename(node.init),
+			conditional: inner.rename(node.conditional),
+			step:        inner.rename(node.step),
+			body:        inner.rename(node.body),
+		}
+	case Var:
+		if isRecursive(node) {
+			r = r.shadow(node.name)
+		}
+		node.value = r.rename(node.value)
+		return node
+	case FunctionExpression:
+		node.body = r.shadow(node.parameters...).rename(node.body)
+		return node
+	case Record:
+		fields := []field{}
+		for _, field := range node.fields {
+			field.value = r.rename(field.value)
+			fields = append(fields, field)
+		}
+		node.fields = fields
+		return node
+	case Member:
+		node.object = r.rename(node.object)
+		return node
+	case MemberAssign:
+		node.object = r.rename(node.object)
+		node.value = r.rename(node.value)
+		return node
+	case Import:
+		panic(fmt.Sprintf("%s: import must be at the top level of a module", node.pos))
+	case Export:
+		panic(fmt.Sprintf("%s: export must be at the top level of a module", node.pos))
+	default:
+		panic(fmt.Sprintf("cannot link %T", node))
+	}
+}
+
+func (r renamer) renameAll(nodes []AST) []AST {
+	renamed := []AST{}
+	for _, node := range nodes {
+		renamed = append(renamed, r.rename(node))
+	}
+	return renamed
+}
+
+// renameStatements renames a block, where each declaration shadows the
+// names after it.
+func (r renamer) renameStatements(statements []AST) []AST {
+	renamed := []AST{}
+	for _, statement := range statements {
+		renamed = append(renamed, r.rename(statement))
+		if declaration, ok := statement.(Var); ok {
+			r = r.shadow(declaration.name)
+		}
+	}
+	return renamed
+}
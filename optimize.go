@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// optimize puts every function in SSA form and runs the passes over it
+// until none of them finds anything more to do.
+func (p *irProgram) optimize() {
+	for _, function := range p.functions {
+		function.optimize()
+	}
+}
+
+func (f *irFunction) optimize() {
+	f.toSSA()
+	for changed := true; changed; {
+		changed = f.propagateConstants()
+		changed = f.propagateCopies() || changed
+		changed = f.numberValues() || changed
+		changed = f.eliminateDeadCode() || changed
+		changed = f.simplifyBlocks() || changed
+	}
+	f.verify(true)
+}
+
+// replaceUses makes every instruction use the replacement of a value
+// instead of the value, following replacements of replacements.
+func (f *irFunction) replaceUses(replacements map[irValue]irValue) {
+	if len(replacements) == 0 {
+		return
+	}
+	for _, block := range f.blocks {
+		for _, in := range block.instructions {
+			for i, arg := range in.args {
+				for {
+					replacement, exists := replacements[arg]
+					if !exists {
+						break
+					}
+					arg = replacement
+				}
+				in.args[i] = arg
+			}
+		}
+	}
+}
+
+// removeInstructions drops the instructions for which remove is true.
+func (f *irFunction) removeInstructions(remove func(in *irInstruction) bool) {
+	for _, block := range f.blocks {
+		block.instructions = slices.DeleteFunc(block.instructions, remove)
+	}
+}
+
+// fold computes the result of an operation on constants with the
+// wrapping 32-bit arithmetic of the targets.
+func fold(op irOp, a, b int32) int32 {
+	switch op {
+	case irAdd:
+		return a + b
+	case irSubtract:
+		return a - b
+	case irMultiply:
+		return a * b
+	case irDivide:
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case irRemainder:
+		if b == 0 {
+			return a
+		}
+		return a % b
+	case irEqual:
+		return int32(vmBoolean(a == b))
+	case irNotEqual:
+		return int32(vmBoolean(a != b))
+	case irNegate:
+		return -a
+	case irNot:
+		return int32(vmBoolean(a == 0))
+	}
+	panic(fmt.Sprintf("cannot fold %d", op))
+}
+
+// evaluate returns the value of an instruction whose operands are all
+// constants.
+func evaluate(in *irInstruction, constants map[irValue]int32) (int32, bool) {
+	args := []int32{}
+	for _, arg := range in.args {
+		if in.op == irPhi && arg == in.dst {
+			// a phi may also merge its own value around a loop
+			continue
+		}
+		value, known := constants[arg]
+		if !known {
+			return 0, false
+		}
+		args = append(args, value)
+	}
+	switch in.op {
+	case irCopy:
+		return args[0], true
+	case irPhi:
+		if len(args) == 0 || slices.ContainsFunc(args, func(arg int32) bool { return arg != args[0] }) {
+			return 0, false
+		}
+		return args[0], true
+	case irNegate, irNot:
+		return fold(in.op, args[0], 0), true
+	case irAdd, irSubtract, irMultiply, irDivide, irRemainder, irEqual, irNotEqual:
+		return fold(in.op, args[0], args[1]), true
+	}
+	return 0, false
+}
+
+// destination returns the block a branch or switch continues at when
+// its operand is value.
+func (in *irInstruction) destination(value int32) *irBlock {
+	if in.op == irBranch {
+		if value != 0 {
+			return in.targets[0]
+		}
+		return in.targets[1]
+	}
+	index := slices.IndexFunc(in.cases, func(c int) bool { return int32(c) == value })
+	if index < 0 {
+		index = len(in.cases)
+	}
+	return in.targets[index]
+}
+
+// propagateConstants replaces operations on constants by their results,
+// phis merging the same constant by it, and branches and switches on a
+// constant by a jump, dropping the blocks that can no longer be reached.
+func (f *irFunction) propagateConstants() bool {
+	changed := false
+	constants := make(map[irValue]int32)
+	for _, block := range f.dominance().order {
+		for _, in := range block.instructions {
+			if in.op == irConstant {
+				constants[in.dst] = int32(in.constant)
+			} else if in.op == irBranch || in.op == irSwitch {
+				if value, known := constants[in.args[0]]; known {
+					in.op, in.args, in.targets, in.cases = irJump, nil, []*irBlock{in.destination(value)}, nil
+					changed = true
+				}
+			} else if value, known := evaluate(in, constants); known {
+				in.op, in.args, in.constant = irConstant, nil, int(value)
+				constants[in.dst] = value
+				changed = true
+			}
+		}
+		// a phi that became a constant moves after the remaining phis
+		slices.SortStableFunc(block.instructions, func(a, b *irInstruction) int {
+			return phiRank(a) - phiRank(b)
+		})
+	}
+	if changed {
+		f.finish()
+	}
+	return changed
+}
+
+func phiRank(in *irInstruction) int {
+	if in.op == irPhi {
+		return 0
+	}
+	return 1
+}
+
+// propagateCopies replaces the uses of a copy by its source, as well as
+// the uses of a phi that merges a single value besides its own.
+func (f *irFunction) propagateCopies() bool {
+	replacements := make(map[irValue]irValue)
+	for _, block := range f.blocks {
+		for _, in := range block.instructions {
+			switch in.op {
+			case irCopy:
+				replacements[in.dst] = in.args[0]
+			case irPhi:
+				sources := slices.Compact(slices.Sorted(slices.Values(slices.DeleteFunc(slices.Clone(in.args), func(arg irValue) bool {
+					return arg == in.dst
+				}))))
+				if len(sources) == 1 {
+					replacements[in.dst] = sources[0]
+				}
+			}
+		}
+	}
+	f.removeInstructions(func(in *irInstruction) bool {
+		_, replaced := replacements[in.dst]
+		return in.dst != noValue && replaced
+	})
+	f.replaceUses(replacements)
+	return len(replacements) > 0
+}
+
+// commutative reports whether the operands of op can be swapped.
+func (op irOp) commutative() bool {
+	switch op {
+	case irAdd, irMultiply, irEqual, irNotEqual:
+		return true
+	}
+	return false
+}
+
+// numberValues removes the instructions that compute a value an
+// instruction in a dominating position already has, walking the
+// dominator tree with the values available along the way. Memory,
+// calls and allocation are never shared.
+func (f *irFunction) numberValues() bool {
+	d := f.dominance()
+	replacements := make(map[irValue]irValue)
+	available := make(map[string]irValue)
+	var visit func(block *irBlock)
+	visit = func(block *irBlock) {
+		added := []string{}
+		kept := block.instructions[:0]
+		for _, in := range block.instructions {
+			for i, arg := range in.args {
+				if replacement, exists := replacements[arg]; exists {
+					in.args[i] = replacement
+				}
+			}
+			key, pure := in.valueKey(block)
+			if pure {
+				if value, exists := available[key]; exists {
+					replacements[in.dst] = value
+					continue
+				}
+				available[key] = in.dst
+				added = append(added, key)
+			}
+			kept = append(kept, in)
+		}
+		block.instructions = kept
+		for _, child := range d.children[block] {
+			visit(child)
+		}
+		for _, key := range added {
+			delete(available, key)
+		}
+	}
+	visit(f.blocks[0])
+	f.replaceUses(replacements)
+	return len(replacements) > 0
+}
+
+// valueKey identifies the value an instruction computes, if it depends on
+// nothing but its operands. Phis are only equal within a block.
+func (in *irInstruction) valueKey(block *irBlock) (string, bool) {
+	args := in.args
+	switch {
+	case in.op == irConstant || in.op == irAddress || in.op == irNegate || in.op == irNot:
+	case irBinaryOperators[in.op] != "":
+		if in.op.commutative() && args[0] > args[1] {
+			args = []irValue{args[1], args[0]}
+		}
+	case in.op == irPhi:
+		return fmt.Sprintf("%d %s %v", in.op, block.label, args), true
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%d %d %s %v", in.op, int32(in.constant), in.symbol, args), true
+}
+
+// eliminateDeadCode removes the instructions whose results nothing with
+// a side effect depends on.
+func (f *irFunction) eliminateDeadCode() bool {
+	definitions := make(map[irValue]*irInstruction)
+	work := []*irInstruction{}
+	live := make(map[*irInstruction]bool)
+	for _, block := range f.blocks {
+		for _, in := range block.instructions {
+			if in.dst != noValue {
+				definitions[in.dst] = in
+			}
+			if in.op.hasSideEffect() {
+				live[in] = true
+				work = append(work, in)
+			}
+		}
+	}
+	for len(work) > 0 {
+		in := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range in.args {
+			if definition := definitions[arg]; !live[definition] {
+				live[definition] = true
+				work = append(work, definition)
+			}
+		}
+	}
+	changed := false
+	f.removeInstructions(func(in *irInstruction) bool {
+		changed = changed || !live[in]
+		return !live[in]
+	})
+	return changed
+}
+
+// simplifyBlocks turns branches with a single target into jumps, skips
+// blocks that do nothing but jump to a block without phis, and merges a
+// block into its only predecessor when that jumps nowhere else.
+func (f *irFunction) simplifyBlocks() bool {
+	changed := false
+	forward := make(map[*irBlock]*irBlock)
+	for _, block := range f.blocks {
+		in := block.terminator()
+		if (in.op == irBranch || in.op == irSwitch) &&
+			!slices.ContainsFunc(in.targets, func(target *irBlock) bool { return target != in.targets[0] }) {
+			in.op, in.args, in.targets, in.cases = irJump, nil, in.targets[:1], nil
+			changed = true
+		}
+		if block != f.blocks[0] && len(block.instructions) == 1 && in.op == irJump &&
+			in.targets[0] != block && in.targets[0].phis() == 0 {
+			forward[block] = in.targets[0]
+		}
+	}
+	for _, block := range f.blocks {
+		in := block.terminator()
+		for i, target := range in.targets {
+			// a cycle of empty blocks is an infinite loop, which stays
+			for seen := map[*irBlock]bool{}; forward[target] != nil && !seen[target]; target = forward[target] {
+				seen[target] = true
+			}
+			if target != in.targets[i] {
+				in.targets[i] = target
+				changed = true
+			}
+		}
+	}
+	if changed {
+		f.finish()
+	}
+
+	for _, block := range f.blocks[1:] {
+		if len(block.predecessors) != 1 {
+			continue
+		}
+		predecessor := block.predecessors[0]
+		if predecessor == block || len(predecessor.instructions) == 0 || predecessor.terminator().op != irJump {
+			continue
+		}
+		for _, in := range block.instructions[:block.phis()] {
+			in.op = irCopy
+		}
+		predecessor.instructions = append(predecessor.instructions[:len(predecessor.instructions)-1], block.instructions...)
+		block.instructions = nil
+		for _, successor := range predecessor.successors() {
+			for i, from := range successor.predecessors {
+				if from == block {
+					successor.predecessors[i] = predecessor
+				}
+			}
+		}
+		changed = true
+	}
+	if changed {
+		f.blocks = slices.DeleteFunc(f.blocks, func(block *irBlock) bool { return len(block.instructions) == 0 })
+		f.finish()
+	}
+	return changed
+}
@@ -1,6 +1,10 @@
 package main
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // Parser Combinators for Expressions and Statements
 var (
@@ -22,33 +26,66 @@ var (
 	FUNCTION = token(`function\b`)
 	IF       = token(`if\b`)
 	WHILE    = token(`while\b`)
+	FOR      = token(`for\b`)
+	BREAK    = token(`break\b`)
+	CONTINUE = token(`continue\b`)
 	ELSE     = token(`else\b`)
 	RETURN   = token(`return\b`)
 	VAR      = token(`var\b`)
+	TRUE     = token(`true\b`)
+	FALSE    = token(`false\b`)
+	IMPORT   = token(`import\b`)
+	EXPORT   = token(`export\b`)
+	FROM     = token(`from\b`)
+	EXTERN   = token(`extern\b`)
+	SWITCH   = token(`switch\b`)
+	CASE     = token(`case\b`)
+	DEFAULT  = token(`default\b`)
 
 	COMMA       = token(`,`)
+	COLON       = token(`:`)
+	DOT         = token(`\.`)
+	LESS        = token(`<`)
+	GREATER     = token(`>`)
 	SEMICOLON   = token(`;`)
 	LEFT_PAREN  = token(`\(`)
 	RIGHT_PAREN = token(`\)`)
 	LEFT_BRACE  = token(`\{`)
 	RIGHT_BRACE = token(`\}`)
 
-	NUMBER = Map(token(`[0-9]+`), func(digits string) AST {
-		val, _ := strconv.Atoi(digits)
-		return Number{value: val}
+	// NUMBER <- decimal, 0x hexadecimal, 0b binary or 0o octal digits with
+	// optional _ separators, or a character in single quotes
+	NUMBER = Bind(Located(), func(pos Position) Parser[AST] {
+		return Or(
+			Bind(token(`(?:0[xX][0-9a-fA-F_]+|0[bB][01_]+|0[oO][0-7_]+|[0-9][0-9_]*)`), numberLiteral(pos)),
+			Bind(token(`'(\\.|[^'\\\n])'`), characterLiteral(pos)),
+		)
 	})
 
+	BOOLEAN = Or(
+		And(TRUE, Constant[AST](Boolean{value: true})),
+		And(FALSE, Constant[AST](Boolean{value: false})),
+	)
+
 	ID = token(`[a-zA-Z_][a-zA-Z0-9_]*`)
 
-	idParser = Map(ID, func(x string) AST {
-		return Id{value: x}
+	// STRING has no escapes; it only names modules
+	STRING = Map(token(`"[^"\n]*"`), func(quoted string) string {
+		return quoted[1 : len(quoted)-1]
+	})
+
+	idParser = Bind(Located(), func(pos Position) Parser[AST] {
+		return Map(ID, func(x string) AST {
+			return Id{value: x, pos: pos}
+		})
 	})
 )
 
 // Operators
 var (
-	NOT   = Map(token(`!`), func(_ string) AST { return Not{} })
-	EQUAL = Map(token(`==`), func(_ string) func(AST, AST) AST {
+	NOT    = Map(token(`!`), func(_ string) AST { return Not{} })
+	NEGATE = Map(token(`-`), func(_ string) AST { return Negate{} })
+	EQUAL  = Map(token(`==`), func(_ string) func(AST, AST) AST {
 		return func(l, r AST) AST { return Equal{left: l, right: r} }
 	})
 	NOT_EQUAL = Map(token(`!=`), func(_ string) func(AST, AST) AST {
@@ -66,14 +103,48 @@ var (
 	SLASH = Map(token(`/`), func(_ string) func(AST, AST) AST {
 		return func(l, r AST) AST { return Divide{left: l, right: r} }
 	})
-	ASSIGN_OP = Map(token(`=`), func(_ string) func(string, AST) AST {
-		return func(name string, value AST) AST { return Assign{name: name, value: value} }
+	PERCENT = Map(token(`%`), func(_ string) func(AST, AST) AST {
+		return func(l, r AST) AST { return Modulo{left: l, right: r} }
+	})
+	ASSIGN = token(`=`)
+
+	// ASSIGN_OP yields how the assigned value combines with the target's
+	// current value, or nil for plain assignment.
+	ASSIGN_OP = Or(
+		Map(ASSIGN, func(_ string) update { return nil }),
+		Map(token(`\+=`), func(_ string) update {
+			return func(current, value AST) AST { return Add{left: current, right: value} }
+		}),
+		Map(token(`-=`), func(_ string) update {
+			return func(current, value AST) AST { return Subtract{left: current, right: value} }
+		}),
+		Map(token(`\*=`), func(_ string) update {
+			return func(current, value AST) AST { return Multiply{left: current, right: value} }
+		}),
+		Map(token(`/=`), func(_ string) update {
+			return func(current, value AST) AST { return Divide{left: current, right: value} }
+		}),
+		Map(token(`%=`), func(_ string) update {
+			return func(current, value AST) AST { return Modulo{left: current, right: value} }
+		}),
+	)
+	INCREMENT = Map(token(`\+\+`), func(_ string) update {
+		return func(current, _ AST) AST { return Add{left: current, right: Number{value: 1}} }
+	})
+	DECREMENT = Map(token(`--`), func(_ string) update {
+		return func(current, _ AST) AST { return Subtract{left: current, right: Number{value: 1}} }
 	})
 )
 
+// update computes the value to store from the current value of an
+// assignment's target and the assigned value.
+type update func(current, value AST) AST
+
 var (
 	expression Parser[AST]
 	statement  Parser[AST]
+	parameters Parser[[]parameter]
+	typeParser Parser[Type]
 	parser     Parser[AST]
 )
 
@@ -87,9 +158,17 @@ func init() {
 		return getStatementParser().Parse(source)
 	}}
 
+	parameters = Parser[[]parameter]{func(source *Source) *ParseResult[[]parameter] {
+		return getParametersParser().Parse(source)
+	}}
+
+	typeParser = Parser[Type]{func(source *Source) *ParseResult[Type] {
+		return getTypeParser().Parse(source)
+	}}
+
 	parser = Map(And(ignored, Many(statement)),
 		func(statements []AST) AST {
-			return Block{statements: statements}
+			return Program{statements: statements}
 		})
 }
 
@@ -106,35 +185,107 @@ func getComparisonParser() Parser[AST] {
 	)
 
 	// call <- ID LEFT_PAREN args RIGHT_PAREN
-	call := Bind(ID, func(callee string) Parser[AST] {
-		return And(LEFT_PAREN, Bind(args, func(args []AST) Parser[AST] {
-			if callee == "__assert" {
-				return And(RIGHT_PAREN, Constant[AST](Assert{condition: args[0]}))
-			} else {
-				return And(RIGHT_PAREN, Constant[AST](Call{callee: callee, args: args}))
-			}
-		}))
+	call := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(ID, func(callee string) Parser[AST] {
+			return And(LEFT_PAREN, Bind(args, func(args []AST) Parser[AST] {
+				if callee == "__assert" {
+					return And(RIGHT_PAREN, Constant[AST](Assert{condition: args[0]}))
+				} else {
+					return And(RIGHT_PAREN, Constant[AST](Call{callee: callee, args: args, pos: pos}))
+				}
+			}))
+		})
+	})
+
+	// functionExpression <- FUNCTION LEFT_PAREN parameters RIGHT_PAREN (COLON type)?
+	//                       LEFT_BRACE statement* RIGHT_BRACE
+	functionExpression := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(And(FUNCTION, LEFT_PAREN), parameters), func(params []parameter) Parser[AST] {
+			return Bind(And(RIGHT_PAREN, Maybe(And(COLON, typeParser))), func(result *Type) Parser[AST] {
+				return Bind(And(LEFT_BRACE, Many(statement)), func(statements []AST) Parser[AST] {
+					return And(RIGHT_BRACE, Constant[AST](FunctionExpression{
+						parameters: parameterNames(params),
+						signature:  signature(params, result),
+						body:       Block{statements: localFunctions(statements)},
+						pos:        pos,
+					}))
+				})
+			})
+		})
+	})
+
+	// field <- ID COLON expression
+	fieldParser := Bind(ID, func(name string) Parser[field] {
+		return Map(And(COLON, expression), func(value AST) field {
+			return field{name: name, value: value}
+		})
+	})
+
+	// record <- LEFT_BRACE (field (COMMA field)*)? RIGHT_BRACE
+	record := Bind(Located(), func(pos Position) Parser[AST] {
+		fields := Or(
+			Bind(fieldParser, func(first field) Parser[[]field] {
+				return Map(Many(And(COMMA, fieldParser)), func(rest []field) []field {
+					return append([]field{first}, rest...)
+				})
+			}),
+			Constant([]field{}),
+		)
+		return Bind(And(LEFT_BRACE, fields), func(fields []field) Parser[AST] {
+			return And(RIGHT_BRACE, Constant[AST](Record{fields: fields, pos: pos}))
+		})
 	})
 
-	// atom <- call / ID / NUMBER / LEFT_PAREN expression RIGHT_PAREN
-	atom := Or(call, idParser, NUMBER,
+	// atom <- functionExpression / record / call / ID / NUMBER / BOOLEAN / LEFT_PAREN expression RIGHT_PAREN
+	atom := Or(functionExpression, record, call, BOOLEAN, idParser, NUMBER,
 		Bind(And(LEFT_PAREN, expression), func(e AST) Parser[AST] {
 			return And(RIGHT_PAREN, Constant(e))
 		}))
 
-	// unary <- NOT? atom
-	unary := Bind(Maybe(NOT), func(not *AST) Parser[AST] {
-		return Map(atom, func(term AST) AST {
-			if not != nil {
-				return Not{term: term}
-			} else {
-				return term
+	// member <- DOT ID
+	member := And(DOT, Bind(Located(), func(pos Position) Parser[func(AST) AST] {
+		return Map(ID, func(name string) func(AST) AST {
+			return func(object AST) AST { return Member{object: object, field: name, pos: pos} }
+		})
+	}))
+
+	// postfix <- atom member*
+	postfix := Bind(atom, func(object AST) Parser[AST] {
+		return Map(Many(member), func(members []func(AST) AST) AST {
+			for _, member := range members {
+				object = member(object)
 			}
+			return object
 		})
 	})
 
-	// product <- unary ((STAR / SLASH) unary)*
-	product := infix(Or(STAR, SLASH), unary)
+	// unary <- (NOT / NEGATE)? postfix
+	unary := Bind(Maybe(Or(NOT, NEGATE)), func(op *AST) Parser[AST] {
+		return Bind(Located(), func(pos Position) Parser[AST] {
+			return Bind(postfix, func(term AST) Parser[AST] {
+				number, literal := term.(Number)
+				if op != nil {
+					if _, ok := (*op).(Negate); ok {
+						// fold negative literals so that -2147483648 stays a constant
+						if literal {
+							return Constant[AST](Number{value: -number.value})
+						}
+						return Constant[AST](Negate{term: term})
+					}
+				}
+				if literal && number.value == 1<<31 {
+					return Error[AST](fmt.Sprintf("%s: number literal %d does not fit in 32 bits", pos, number.value))
+				}
+				if op == nil {
+					return Constant(term)
+				}
+				return Constant[AST](Not{term: term})
+			})
+		})
+	})
+
+	// product <- unary ((STAR / SLASH / PERCENT) unary)*
+	product := infix(Or(STAR, SLASH, PERCENT), unary)
 
 	// sum <- product ((PLUS / MINUS) product)*
 	sum := infix(Or(PLUS, MINUS), product)
@@ -165,26 +316,22 @@ func infix(operatOr Parser[func(AST, AST) AST], termParser Parser[AST]) Parser[A
 
 func getStatementParser() Parser[AST] {
 	// returnStatement <- RETURN expression SEMICOLON
-	returnStatement := Bind(And(RETURN, expression),
-		func(term AST) Parser[AST] {
-			return And(SEMICOLON, Constant[AST](Return{term: term}))
+	returnStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(RETURN, expression), func(term AST) Parser[AST] {
+			return And(SEMICOLON, Constant[AST](Return{term: term, pos: pos}))
 		})
-
-	// expressionStatement <- expression SEMICOLON
-	expressionStatement := Bind(expression, func(term AST) Parser[AST] {
-		return And(SEMICOLON, Constant(term))
 	})
 
-	// ifStatement <- IF LEFT_PAREN expression RIGHT_PAREN statement ELSE statement
+	// ifStatement <- IF LEFT_PAREN expression RIGHT_PAREN statement (ELSE statement)?
 	ifStatement := Bind(And(And(IF, LEFT_PAREN), expression),
 		func(conditional AST) Parser[AST] {
 			return Bind(And(RIGHT_PAREN, statement), func(consequence AST) Parser[AST] {
-				return Bind(And(ELSE, statement), func(alternative AST) Parser[AST] {
-					return Constant[AST](If{
-						conditional: conditional,
-						consequence: consequence,
-						alternative: alternative,
-					})
+				return Map(Maybe(And(ELSE, statement)), func(alternative *AST) AST {
+					node := If{conditional: conditional, consequence: consequence}
+					if alternative != nil {
+						node.alternative = *alternative
+					}
+					return node
 				})
 			})
 		})
@@ -200,65 +347,386 @@ func getStatementParser() Parser[AST] {
 			})
 		})
 
-	// varStatement <- VAR ID ASSIGN expression SEMICOLON
-	varStatement := Bind(And(VAR, ID),
-		func(name string) Parser[AST] {
-			return Bind(And(ASSIGN_OP, expression), func(value AST) Parser[AST] {
-				return And(SEMICOLON, Constant[AST](Var{name: name, value: value}))
+	// varStatement <- VAR ID (COLON type)? ASSIGN expression SEMICOLON
+	varStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(VAR, ID), func(name string) Parser[AST] {
+			return Bind(Maybe(And(COLON, typeParser)), func(typ *Type) Parser[AST] {
+				return Bind(And(ASSIGN, expression), func(value AST) Parser[AST] {
+					node := Var{name: name, value: value, pos: pos}
+					if typ != nil {
+						node.typ = *typ
+					}
+					return And(SEMICOLON, Constant[AST](node))
+				})
 			})
 		})
+	})
+
+	// assignment <- expression (ASSIGN_OP expression / INCREMENT / DECREMENT)?
+	//             / (INCREMENT / DECREMENT) expression
+	// where the target of an operator must be a variable or a field; the
+	// expression is parsed only once, so a bare expression is kept as is
+	step := Or(INCREMENT, DECREMENT)
+	assignment := Or(
+		Bind(expression, func(target AST) Parser[AST] {
+			return Or(
+				Bind(ASSIGN_OP, func(op update) Parser[AST] {
+					return Bind(expression, func(value AST) Parser[AST] {
+						return assign(target, op, value)
+					})
+				}),
+				Bind(step, func(op update) Parser[AST] {
+					return assign(target, op, nil)
+				}),
+				Constant(target),
+			)
+		}),
+		Bind(step, func(op update) Parser[AST] {
+			return Bind(expression, func(target AST) Parser[AST] {
+				return assign(target, op, nil)
+			})
+		}),
+	)
 
-	// assignmentStatement <- ID ASSIGN expression SEMICOLON
-	assignmentStatement := Bind(ID, func(name string) Parser[AST] {
-		return Bind(And(ASSIGN_OP, expression), func(value AST) Parser[AST] {
-			return And(SEMICOLON, Constant[AST](Assign{name: name, value: value}))
+	// assignmentStatement <- assignment SEMICOLON
+	assignmentStatement := Bind(assignment, func(term AST) Parser[AST] {
+		return And(SEMICOLON, Constant(term))
+	})
+
+	// forStatement <- FOR LEFT_PAREN (varStatement / assignmentStatement / SEMICOLON)
+	//                 expression? SEMICOLON assignment? RIGHT_PAREN statement
+	forInit := Or(varStatement, assignmentStatement, And(SEMICOLON, Constant[AST](nil)))
+	forStatement := Bind(And(And(FOR, LEFT_PAREN), forInit), func(init AST) Parser[AST] {
+		return Bind(Maybe(expression), func(conditional *AST) Parser[AST] {
+			return Bind(And(SEMICOLON, Maybe(assignment)), func(step *AST) Parser[AST] {
+				return Bind(And(RIGHT_PAREN, statement), func(body AST) Parser[AST] {
+					node := For{init: init, body: body}
+					if conditional != nil {
+						node.conditional = *conditional
+					}
+					if step != nil {
+						node.step = *step
+					}
+					return Constant[AST](node)
+				})
+			})
 		})
 	})
 
+	// clause <- (CASE expression / DEFAULT) COLON statement*
+	clause := Bind(Or(
+		Bind(And(CASE, expression), func(value AST) Parser[switchCase] {
+			number, ok := value.(Number)
+			if !ok {
+				return Error[switchCase]("Case value must be a number literal")
+			}
+			return Constant(switchCase{value: number.value})
+		}),
+		And(DEFAULT, Constant(switchCase{isDefault: true})),
+	), func(clause switchCase) Parser[switchCase] {
+		return Map(And(COLON, Many(statement)), func(statements []AST) switchCase {
+			clause.statements = localFunctions(statements)
+			return clause
+		})
+	})
+
+	// switchStatement <- SWITCH LEFT_PAREN expression RIGHT_PAREN LEFT_BRACE clause* RIGHT_BRACE
+	switchStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(And(SWITCH, LEFT_PAREN), expression), func(value AST) Parser[AST] {
+			return Bind(And(And(RIGHT_PAREN, LEFT_BRACE), Many(clause)), func(cases []switchCase) Parser[AST] {
+				return And(RIGHT_BRACE, Constant[AST](Switch{value: value, cases: cases, pos: pos}))
+			})
+		})
+	})
+
+	// breakStatement <- BREAK SEMICOLON
+	breakStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return And(And(BREAK, SEMICOLON), Constant[AST](Break{pos: pos}))
+	})
+
+	// continueStatement <- CONTINUE SEMICOLON
+	continueStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return And(And(CONTINUE, SEMICOLON), Constant[AST](Continue{pos: pos}))
+	})
+
 	// blockStatement <- LEFT_BRACE statement* RIGHT_BRACE
 	blockStatement := Bind(And(LEFT_BRACE, Many(statement)),
 		func(statements []AST) Parser[AST] {
-			return And(RIGHT_BRACE, Constant[AST](Block{statements: statements}))
+			return And(RIGHT_BRACE, Constant[AST](Block{statements: localFunctions(statements)}))
 		})
 
-	// functionStatement <- FUNCTION ID LEFT_PAREN parameters RIGHT_PAREN blockStatement
-	functionStatement := Bind(And(FUNCTION, ID), func(name string) Parser[AST] {
-		return Bind(And(LEFT_PAREN, parameters), func(parameters []string) Parser[AST] {
-			return Bind(And(RIGHT_PAREN, blockStatement), func(block AST) Parser[AST] {
-				if name == "__main" {
-					if blockStmt, ok := block.(Block); ok {
-						return Constant[AST](Main(blockStmt))
-					}
-				}
-				return Constant[AST](Function{
-					name:       name,
-					parameters: parameters,
-					body:       block,
+	// functionStatement <- FUNCTION ID LEFT_PAREN parameters RIGHT_PAREN (COLON type)? blockStatement
+	functionStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(FUNCTION, ID), func(name string) Parser[AST] {
+			return Bind(And(LEFT_PAREN, parameters), func(params []parameter) Parser[AST] {
+				return Bind(And(RIGHT_PAREN, Maybe(And(COLON, typeParser))), func(result *Type) Parser[AST] {
+					return Bind(blockStatement, func(block AST) Parser[AST] {
+						if name == "__main" {
+							if blockStmt, ok := block.(Block); ok {
+								return Constant[AST](Main(blockStmt))
+							}
+						}
+						return Constant[AST](Function{
+							name:       name,
+							parameters: parameterNames(params),
+							signature:  signature(params, result),
+							body:       block,
+							pos:        pos,
+						})
+					})
+				})
+			})
+		})
+	})
+
+	// importStatement <- IMPORT LEFT_BRACE ID (COMMA ID)* RIGHT_BRACE FROM STRING SEMICOLON
+	importStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(And(IMPORT, LEFT_BRACE), ID), func(first string) Parser[AST] {
+			return Bind(Many(And(COMMA, ID)), func(rest []string) Parser[AST] {
+				return Bind(And(And(RIGHT_BRACE, FROM), STRING), func(path string) Parser[AST] {
+					names := append([]string{first}, rest...)
+					return And(SEMICOLON, Constant[AST](Import{names: names, path: path, pos: pos}))
 				})
 			})
 		})
 	})
 
+	// externStatement <- EXTERN FUNCTION ID LEFT_PAREN parameters RIGHT_PAREN (COLON type)? SEMICOLON
+	externStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Bind(And(And(EXTERN, FUNCTION), ID), func(name string) Parser[AST] {
+			return Bind(And(LEFT_PAREN, parameters), func(params []parameter) Parser[AST] {
+				return Bind(And(RIGHT_PAREN, Maybe(And(COLON, typeParser))), func(result *Type) Parser[AST] {
+					return And(SEMICOLON, Constant[AST](Extern{
+						name:       name,
+						parameters: parameterNames(params),
+						signature:  signature(params, result),
+						pos:        pos,
+					}))
+				})
+			})
+		})
+	})
+
+	// exportStatement <- EXPORT (functionStatement / varStatement)
+	exportStatement := Bind(Located(), func(pos Position) Parser[AST] {
+		return Map(And(EXPORT, Or(functionStatement, varStatement)), func(declaration AST) AST {
+			return Export{declaration: declaration, pos: pos}
+		})
+	})
+
 	return Or(
+		importStatement,
+		externStatement,
+		exportStatement,
 		returnStatement,
 		functionStatement,
 		ifStatement,
 		whileStatement,
+		forStatement,
+		switchStatement,
+		breakStatement,
+		continueStatement,
 		varStatement,
 		assignmentStatement,
 		blockStatement,
-		expressionStatement,
 	)
 }
 
-// parameters <- (ID (COMMA ID)*)?
-var parameters = Or(
-	Bind(ID, func(param string) Parser[[]string] {
-		return Bind(Many(And(COMMA, ID)),
-			func(params []string) Parser[[]string] {
-				allParams := append([]string{param}, params...)
-				return Constant(allParams)
+// numberLiteral converts digits to a Number. Decimal literals go up to
+// 2147483648, which only unary minus accepts, so that the negated literal
+// is the lowest number; the other bases spell out the 32 bits, so
+// 0xffffffff is -1.
+func numberLiteral(pos Position) func(string) Parser[AST] {
+	return func(literal string) Parser[AST] {
+		digits := strings.ReplaceAll(literal, "_", "")
+		base := 10
+		if len(digits) > 1 && strings.ContainsAny(digits[1:2], "xXbBoO") {
+			base = map[byte]int{'x': 16, 'b': 2, 'o': 8}[digits[1]|0x20]
+			digits = digits[2:]
+		}
+		if digits == "" || strings.HasPrefix(literal, "_") || strings.HasSuffix(literal, "_") {
+			return Error[AST](fmt.Sprintf("%s: malformed number literal %s", pos, literal))
+		}
+		value, err := strconv.ParseUint(digits, base, 32)
+		if err != nil || base == 10 && value > 1<<31 {
+			return Error[AST](fmt.Sprintf("%s: number literal %s does not fit in 32 bits", pos, literal))
+		}
+		if base != 10 {
+			return Constant[AST](Number{value: int(int32(uint32(value)))})
+		}
+		return Constant[AST](Number{value: int(value)})
+	}
+}
+
+// characterLiteral converts a quoted character to its code. The escapes
+// are those of C.
+func characterLiteral(pos Position) func(string) Parser[AST] {
+	escapes := map[string]rune{
+		`\n`: '\n', `\t`: '\t', `\r`: '\r', `\0`: 0, `\\`: '\\', `\'`: '\'', `\"`: '"',
+	}
+	return func(quoted string) Parser[AST] {
+		character := quoted[1 : len(quoted)-1]
+		if strings.HasPrefix(character, `\`) {
+			code, ok := escapes[character]
+			if !ok {
+				return Error[AST](fmt.Sprintf("%s: unknown escape %s", pos, character))
+			}
+			return Constant[AST](Number{value: int(code)})
+		}
+		return Constant[AST](Number{value: int([]rune(character)[0])})
+	}
+}
+
+// updatedObject holds the record whose field a compound assignment
+// updates. It cannot clash with an identifier.
+const updatedObject = "$object"
+
+// assign stores value, combined with the current value by op unless op is
+// nil, into target, and fails unless target is a variable or a field. A
+// compound assignment to a field of a computed record evaluates the record
+// only once, into a temporary.
+func assign(target AST, op update, value AST) Parser[AST] {
+	switch target := target.(type) {
+	case Id:
+		if op != nil {
+			value = op(target, value)
+		}
+		return Constant[AST](Assign{name: target.value, value: value, pos: target.pos})
+	case Member:
+		if op == nil {
+			return Constant[AST](MemberAssign{object: target.object, field: target.field, value: value, pos: target.pos})
+		}
+		if _, ok := target.object.(Id); ok {
+			return Constant[AST](MemberAssign{
+				object: target.object,
+				field:  target.field,
+				value:  op(target, value),
+				pos:    target.pos,
 			})
-	}),
-	Constant([]string{}),
-)
+		}
+		object := Id{value: updatedObject, pos: target.pos}
+		return Constant[AST](Block{statements: []AST{
+			Var{name: updatedObject, value: target.object, pos: target.pos},
+			MemberAssign{
+				object: object,
+				field:  target.field,
+				value:  op(Member{object: object, field: target.field, pos: target.pos}, value),
+				pos:    target.pos,
+			},
+		}})
+	default:
+		return Parser[AST]{}
+	}
+}
+
+// localFunctions turns function declarations inside a block into variables
+// holding closures.
+func localFunctions(statements []AST) []AST {
+	result := []AST{}
+	for _, statement := range statements {
+		if function, ok := statement.(Function); ok {
+			result = append(result, Var{name: function.name, pos: function.pos, value: FunctionExpression{
+				parameters: function.parameters,
+				signature:  function.signature,
+				body:       function.body,
+				pos:        function.pos,
+			}})
+		} else {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+type parameter struct {
+	name string
+	typ  Type
+}
+
+func parameterNames(params []parameter) []string {
+	names := []string{}
+	for _, param := range params {
+		names = append(names, param.name)
+	}
+	return names
+}
+
+// signature collects the annotations of a function; parameters and the
+// result without one are left nil.
+func signature(params []parameter, result *Type) FunctionType {
+	function := FunctionType{parameters: []Type{}}
+	for _, param := range params {
+		function.parameters = append(function.parameters, param.typ)
+	}
+	if result != nil {
+		function.result = *result
+	}
+	return function
+}
+
+func getParametersParser() Parser[[]parameter] {
+	// parameter <- ID (COLON type)?
+	param := Bind(ID, func(name string) Parser[parameter] {
+		return Map(Maybe(And(COLON, typeParser)), func(typ *Type) parameter {
+			if typ == nil {
+				return parameter{name: name}
+			}
+			return parameter{name: name, typ: *typ}
+		})
+	})
+
+	// parameters <- (parameter (COMMA parameter)*)?
+	return Or(
+		Bind(param, func(first parameter) Parser[[]parameter] {
+			return Bind(Many(And(COMMA, param)),
+				func(params []parameter) Parser[[]parameter] {
+					allParams := append([]parameter{first}, params...)
+					return Constant(allParams)
+				})
+		}),
+		Constant([]parameter{}),
+	)
+}
+
+func getTypeParser() Parser[Type] {
+	// fieldType <- ID COLON type
+	fieldType := Bind(ID, func(name string) Parser[RecordField] {
+		return Map(And(COLON, typeParser), func(typ Type) RecordField {
+			return RecordField{name: name, typ: typ}
+		})
+	})
+
+	// recordType <- LEFT_BRACE (fieldType (COMMA fieldType)*)? RIGHT_BRACE
+	fieldTypes := Or(
+		Bind(fieldType, func(first RecordField) Parser[[]RecordField] {
+			return Map(Many(And(COMMA, fieldType)), func(rest []RecordField) []RecordField {
+				return append([]RecordField{first}, rest...)
+			})
+		}),
+		Constant([]RecordField{}),
+	)
+	recordType := Bind(And(LEFT_BRACE, fieldTypes), func(fields []RecordField) Parser[Type] {
+		return And(RIGHT_BRACE, Constant[Type](NewRecordType(fields)))
+	})
+
+	// type <- recordType / ID (LESS type GREATER)?
+	return Or(recordType, Bind(ID, func(name string) Parser[Type] {
+		argument := Bind(And(LESS, typeParser), func(argument Type) Parser[Type] {
+			return And(GREATER, Constant(argument))
+		})
+		return Bind(Maybe(argument), func(argument *Type) Parser[Type] {
+			switch {
+			case name == "number" && argument == nil:
+				return Constant[Type](NumberType{})
+			case name == "boolean" && argument == nil:
+				return Constant[Type](BooleanType{})
+			case name == "void" && argument == nil:
+				return Constant[Type](VoidType{})
+			case name == "Array" && argument != nil:
+				return Constant[Type](ArrayType{element: *argument})
+			default:
+				return Error[Type](fmt.Sprintf("Unknown type: %s", name))
+			}
+		})
+	}))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// fieldFunction returns in r0 the address of field r1 of the record at r0,
+// looking the field up in the record's shape.
+const fieldFunction = "__baseline_field"
+
+// A record is a heap object whose first word points to its shape and whose
+// fields follow in the order of their names, so records with the same
+// fields share a layout. The shape lists the fields' ids for code that
+// does not know the record's type statically.
+type field struct {
+	name  string
+	value AST
+}
+
+// layout returns the field names of a record in layout order.
+func layout(names []string) []string {
+	return slices.Sorted(slices.Values(names))
+}
+
+// fieldOffset is the offset of the field at index in a record's layout.
+func fieldOffset(index int) int {
+	return 4 * (index + 1)
+}
+
+// shape returns the label of the shape of records with these fields.
+func (s *symbols) shape(names []string) string {
+	key := strings.Join(layout(names), ",")
+	if label, exists := s.shapes[key]; exists {
+		return label
+	}
+	label := fmt.Sprintf(".Lshape_%d", len(s.shapes))
+	s.shapes[key] = label
+	s.shapeOrder = append(s.shapeOrder, key)
+	return label
+}
+
+// fieldId numbers each field name used in the program.
+func (s *symbols) fieldId(name string) int {
+	if id, exists := s.fieldIds[name]; exists {
+		return id
+	}
+	id := len(s.fieldIds)
+	s.fieldIds[name] = id
+	return id
+}
+
+func (s *symbols) emitShapes() {
+	if len(s.shapeOrder) == 0 {
+		return
+	}
+	emit(".data")
+	emit(".balign 4")
+	for _, key := range s.shapeOrder {
+		names := strings.Split(key, ",")
+		if key == "" {
+			names = nil
+		}
+		ids := []string{fmt.Sprint(len(names))}
+		for _, name := range names {
+			ids = append(ids, fmt.Sprint(s.fieldId(name)))
+		}
+		emit(fmt.Sprintf("%s:", s.shapes[key]))
+		emit(fmt.Sprintf("  .word %s", strings.Join(ids, ", ")))
+	}
+}
+
+// Record is an object literal such as {x: 1, y: 2}.
+type Record struct {
+	fields []field
+	pos    Position
+}
+
+func (r Record) names() []string {
+	names := []string{}
+	for _, field := range r.fields {
+		names = append(names, field.name)
+	}
+	return names
+}
+
+func (r Record) Equals(other AST) bool {
+	if otherRecord, ok := other.(*Record); ok {
+		if len(r.fields) != len(otherRecord.fields) {
+			return false
+		}
+		for i, field := range r.fields {
+			otherField := otherRecord.fields[i]
+			if field.name != otherField.name || !field.value.Equals(otherField.value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Member reads a field, as in p.x. Its position is that of the field name,
+// which identifies the access to the type checker's layouts.
+type Member struct {
+	object AST
+	field  string
+	pos    Position
+}
+
+func (m Member) Equals(other AST) bool {
+	if otherMember, ok := other.(*Member); ok {
+		return m.field == otherMember.field && m.object.Equals(otherMember.object)
+	}
+	return false
+}
+
+// MemberAssign writes a field, as in p.x = 1, and evaluates to the value.
+type MemberAssign struct {
+	object AST
+	field  string
+	value  AST
+	pos    Position
+}
+
+func (m MemberAssign) Equals(other AST) bool {
+	if otherAssign, ok := other.(*MemberAssign); ok {
+		return m.field == otherAssign.field &&
+			m.object.Equals(otherAssign.object) &&
+			m.value.Equals(otherAssign.value)
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// allocatableRegisters are the callee-saved registers the allocator hands
+// out. r11 is fp, and r0 to r3, ip and lr serve as scratch registers and
+// for calls.
+var allocatableRegisters = []string{"r4", "r5", "r6", "r7", "r8", "r9", "r10"}
+
+// liveRange is the half-open range of positions [from, to) in which a
+// value is live. Instruction k reads its operands at position 2k and
+// writes its result at 2k+1, so an instruction may reuse the register of
+// an operand it reads for the last time.
+type liveRange struct {
+	from, to int
+}
+
+// interval is the lifetime of a virtual register: its ranges in the
+// linear order of the blocks, with holes where it is not live.
+type interval struct {
+	value  irValue
+	ranges []liveRange
+}
+
+func (i *interval) start() int {
+	return i.ranges[0].from
+}
+
+func (i *interval) end() int {
+	return i.ranges[len(i.ranges)-1].to
+}
+
+func (i *interval) add(from, to int) {
+	i.ranges = append(i.ranges, liveRange{from, to})
+}
+
+// normalize sorts the ranges and joins those that touch.
+func (i *interval) normalize() {
+	slices.SortFunc(i.ranges, func(a, b liveRange) int { return a.from - b.from })
+	merged := i.ranges[:1]
+	for _, r := range i.ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.from <= last.to {
+			last.to = max(last.to, r.to)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	i.ranges = merged
+}
+
+func (i *interval) intersects(other *interval) bool {
+	a, b := i.ranges, other.ranges
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].to <= b[0].from {
+			a = a[1:]
+		} else if b[0].to <= a[0].from {
+			b = b[1:]
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+// allocation maps each virtual register to a machine register or to a
+// spill slot, and lists the registers the function must preserve.
+type allocation struct {
+	registers map[irValue]string
+	slots     map[irValue]int
+	used      []string
+}
+
+// liveness computes the registers live on entry to each block.
+func (f *irFunction) liveness() map[*irBlock]map[irValue]bool {
+	live := make(map[*irBlock]map[irValue]bool)
+	for _, block := range f.blocks {
+		live[block] = make(map[irValue]bool)
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, block := range slices.Backward(f.blocks) {
+			in := liveOut(block, live)
+			for _, instruction := range slices.Backward(block.instructions) {
+				if instruction.dst != noValue {
+					delete(in, instruction.dst)
+				}
+				for _, arg := range instruction.args {
+					in[arg] = true
+				}
+			}
+			if !maps.Equal(in, live[block]) {
+				live[block] = in
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+func liveOut(block *irBlock, liveIn map[*irBlock]map[irValue]bool) map[irValue]bool {
+	out := make(map[irValue]bool)
+	for _, successor := range block.successors() {
+		maps.Copy(out, liveIn[successor])
+	}
+	return out
+}
+
+// intervals builds the lifetime of every virtual register the function
+// defines or reads, in the order of their start.
+func (f *irFunction) intervals() []*interval {
+	live := f.liveness()
+	intervals := make(map[irValue]*interval)
+	lifetime := func(value irValue) *interval {
+		if intervals[value] == nil {
+			intervals[value] = &interval{value: value}
+		}
+		return intervals[value]
+	}
+	position := 0
+	for _, block := range f.blocks {
+		from := 2 * position
+		position += len(block.instructions)
+		// end is where the range of each live value being built ends
+		end := make(map[irValue]int)
+		for value := range liveOut(block, live) {
+			end[value] = 2 * position
+		}
+		for k, in := range slices.Backward(block.instructions) {
+			at := 2 * (position - len(block.instructions) + k)
+			if in.dst != noValue {
+				if to, exists := end[in.dst]; exists {
+					lifetime(in.dst).add(at+1, to)
+					delete(end, in.dst)
+				} else {
+					lifetime(in.dst).add(at+1, at+2)
+				}
+			}
+			for _, arg := range in.args {
+				if _, exists := end[arg]; !exists {
+					end[arg] = at + 1
+				}
+			}
+		}
+		for value, to := range end {
+			lifetime(value).add(from, to)
+		}
+	}
+	sorted := slices.Collect(maps.Values(intervals))
+	for _, i := range sorted {
+		i.normalize()
+	}
+	slices.SortFunc(sorted, func(a, b *interval) int {
+		if a.start() != b.start() {
+			return a.start() - b.start()
+		}
+		return int(a.value - b.value)
+	})
+	return sorted
+}
+
+// allocateRegisters assigns the virtual registers of the function, which
+// must be out of SSA form, to machine registers by linear scan over their
+// lifetimes. A register goes preferably to a value copied from or to one
+// that already has it, which removes the copy. When every register is
+// taken, the lifetime reaching furthest goes to a stack slot.
+func (f *irFunction) allocateRegisters() *allocation {
+	related := make(map[irValue][]irValue)
+	for _, block := range f.blocks {
+		for _, in := range block.instructions {
+			if in.op == irCopy {
+				related[in.dst] = append(related[in.dst], in.args[0])
+				related[in.args[0]] = append(related[in.args[0]], in.dst)
+			}
+		}
+	}
+
+	a := &allocation{registers: make(map[irValue]string), slots: make(map[irValue]int)}
+	assigned := make(map[string][]*interval)
+	spill := func(i *interval) {
+		delete(a.registers, i.value)
+		a.slots[i.value] = len(a.slots)
+	}
+	assign := func(i *interval, register string) {
+		a.registers[i.value] = register
+		assigned[register] = append(assigned[register], i)
+		if !slices.Contains(a.used, register) {
+			a.used = append(a.used, register)
+		}
+	}
+	conflicts := func(i *interval, register string) []*interval {
+		others := []*interval{}
+		for _, other := range assigned[register] {
+			if other.intersects(i) {
+				others = append(others, other)
+			}
+		}
+		return others
+	}
+
+	for _, current := range f.intervals() {
+		// lifetimes that ended cannot conflict with any later one
+		for register, intervals := range assigned {
+			assigned[register] = slices.DeleteFunc(intervals, func(i *interval) bool {
+				return i.end() <= current.start()
+			})
+		}
+		candidates := []string{}
+		for _, value := range related[current.value] {
+			if register, exists := a.registers[value]; exists {
+				candidates = append(candidates, register)
+			}
+		}
+		candidates = append(candidates, allocatableRegisters...)
+		if index := slices.IndexFunc(candidates, func(register string) bool {
+			return len(conflicts(current, register)) == 0
+		}); index >= 0 {
+			assign(current, candidates[index])
+			continue
+		}
+
+		furthest, victim := current.end(), ""
+		for _, register := range allocatableRegisters {
+			end := 0
+			for _, other := range conflicts(current, register) {
+				end = max(end, other.end())
+			}
+			if end > furthest {
+				furthest, victim = end, register
+			}
+		}
+		if victim == "" {
+			spill(current)
+			continue
+		}
+		for _, other := range conflicts(current, victim) {
+			spill(other)
+			assigned[victim] = slices.DeleteFunc(assigned[victim], func(i *interval) bool { return i == other })
+		}
+		assign(current, victim)
+	}
+	slices.SortFunc(a.used, func(x, y string) int {
+		return slices.Index(allocatableRegisters, x) - slices.Index(allocatableRegisters, y)
+	})
+	return a
+}
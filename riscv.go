@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// riscv32 emits RV32IM code following the ILP32 calling convention:
+// arguments go in a0-a7, frames are linked through s0 and sp stays 16-byte
+// aligned, so every push takes 16 bytes. a0 is the accumulator, a1 the
+// operand register, and t1 holds the code address of a closure being
+// called. t2 holds offsets too large for an instruction.
+type riscv32 struct{}
+
+func (riscv32) wordSize() int          { return 4 }
+func (riscv32) argumentRegisters() int { return 8 }
+func (riscv32) stackAlignment() int    { return 16 }
+
+func (riscv32) name(r register) string {
+	switch r {
+	case accumulator:
+		return "a0"
+	case operand:
+		return "a1"
+	default:
+		return "s0"
+	}
+}
+
+// fitsImmediate reports whether value fits the 12-bit signed immediate of
+// addi, lw and sw.
+func fitsImmediate(value int) bool {
+	return value >= -2048 && value <= 2047
+}
+
+func (r riscv32) emitPrologue(parameters, frameSize int) {
+	emit("  addi sp, sp, -16")
+	emit("  sw s0, 0(sp)")
+	emit("  sw ra, 4(sp)")
+	emit("  mv s0, sp")
+	if frameSize > 0 {
+		r.emitReserve(frameSize)
+	}
+	for i := 0; i < parameters; i++ {
+		r.memory("sw", fmt.Sprintf("a%d", i), "s0", -4*(i+1))
+	}
+}
+
+func (riscv32) emitReturn() {
+	emit("  mv sp, s0")
+	emit("  lw ra, 4(sp)")
+	emit("  lw s0, 0(sp)")
+	emit("  addi sp, sp, 16")
+	emit("  ret")
+}
+
+func (riscv32) emitFunctionEnd() {}
+
+func (riscv32) emitSetStackBase() {
+	emit("  la a1, __baseline_stack_base")
+	emit("  sw s0, 0(a1)")
+}
+
+func (r riscv32) emitNumber(to register, value int) {
+	emit(fmt.Sprintf("  li %s, %d", r.name(to), value))
+}
+
+func (r riscv32) emitAddress(to register, label string) {
+	emit(fmt.Sprintf("  la %s, %s", r.name(to), label))
+}
+
+func (r riscv32) emitMove(to, from register) {
+	emit(fmt.Sprintf("  mv %s, %s", r.name(to), r.name(from)))
+}
+
+// memory emits a load or store of register at offset from base, adding
+// offsets that do not fit the immediate to the base in t2.
+func (riscv32) memory(instruction, register, base string, offset int) {
+	if fitsImmediate(offset) {
+		emit(fmt.Sprintf("  %s %s, %d(%s)", instruction, register, offset, base))
+		return
+	}
+	emit(fmt.Sprintf("  li t2, %d", offset))
+	emit(fmt.Sprintf("  add t2, %s, t2", base))
+	emit(fmt.Sprintf("  %s %s, 0(t2)", instruction, register))
+}
+
+func (r riscv32) emitLoad(to, base register, offset int) {
+	r.memory("lw", r.name(to), r.name(base), offset)
+}
+
+func (r riscv32) emitStore(from, base register, offset int) {
+	r.memory("sw", r.name(from), r.name(base), offset)
+}
+
+func (riscv32) emitPush() {
+	emit("  addi sp, sp, -16")
+	emit("  sw a0, 0(sp)")
+}
+
+func (riscv32) emitPop() {
+	emit("  lw a1, 0(sp)")
+	emit("  addi sp, sp, 16")
+}
+
+func (riscv32) emitPeek() {
+	emit("  lw a1, 0(sp)")
+}
+
+// adjust adds delta to sp.
+func (riscv32) adjust(delta int) {
+	if fitsImmediate(delta) {
+		emit(fmt.Sprintf("  addi sp, sp, %d", delta))
+		return
+	}
+	emit(fmt.Sprintf("  li t2, %d", delta))
+	emit("  add sp, sp, t2")
+}
+
+func (r riscv32) emitReserve(size int) {
+	r.adjust(-size)
+}
+
+func (r riscv32) emitRelease(size int) {
+	r.adjust(size)
+}
+
+func (r riscv32) emitStoreArgument(offset int) {
+	r.memory("sw", "a0", "sp", offset)
+}
+
+func (riscv32) emitLoadArguments(count int) {
+	for i := 0; i < count; i++ {
+		emit(fmt.Sprintf("  lw a%d, %d(sp)", i, 4*i))
+	}
+}
+
+func (riscv32) emitCall(function string) {
+	emit(fmt.Sprintf("  call %s", function))
+}
+
+func (r riscv32) emitPrepareClosureCall(environmentOffset int) {
+	emit("  lw t1, 0(a0)")
+	emit("  lw a1, 4(a0)")
+	r.memory("sw", "a1", "sp", environmentOffset)
+}
+
+func (riscv32) emitCallClosure() {
+	emit("  jalr t1")
+}
+
+func (riscv32) emitNot() {
+	emit("  seqz a0, a0")
+}
+
+func (riscv32) emitNegate() {
+	emit("  neg a0, a0")
+}
+
+// emitBinary masks the quotient of a division by zero, which div leaves
+// all ones, to the 0 ARM gives. rem already returns the dividend then.
+func (riscv32) emitBinary(op operator) {
+	switch op {
+	case addition:
+		emit("  add a0, a1, a0")
+	case subtraction:
+		emit("  sub a0, a1, a0")
+	case multiplication:
+		emit("  mul a0, a1, a0")
+	case division:
+		emit("  snez t0, a0")
+		emit("  div a0, a1, a0")
+		emit("  neg t0, t0")
+		emit("  and a0, a0, t0")
+	case remainder:
+		emit("  rem a0, a1, a0")
+	case equality:
+		emit("  sub a0, a1, a0")
+		emit("  seqz a0, a0")
+	case inequality:
+		emit("  sub a0, a1, a0")
+		emit("  snez a0, a0")
+	}
+}
+
+// The conditional branches reach only 4 KiB, which a long function
+// exceeds, so they skip over a jump instead.
+
+func (riscv32) emitBranchIfZero(target *Label) {
+	skip := NewLabel()
+	emit(fmt.Sprintf("  bnez a0, %s", skip))
+	emit(fmt.Sprintf("  j %s", target))
+	emit(fmt.Sprintf("%s:", skip))
+}
+
+func (r riscv32) emitBranchIfEqual(value int, target *Label) {
+	skip := NewLabel()
+	r.emitNumber(operand, value)
+	emit(fmt.Sprintf("  bne a0, a1, %s", skip))
+	emit(fmt.Sprintf("  j %s", target))
+	emit(fmt.Sprintf("%s:", skip))
+}
+
+func (riscv32) emitJump(target *Label) {
+	emit(fmt.Sprintf("  j %s", target))
+}
+
+// emitJumpTable rebases a0 to the lowest case value; the unsigned
+// comparison also sends values below it to otherwise.
+func (r riscv32) emitJumpTable(low int, table []*Label, otherwise *Label) {
+	addresses := NewLabel()
+	skip := NewLabel()
+	r.emitNumber(operand, low)
+	emit("  sub a0, a0, a1")
+	r.emitNumber(operand, len(table)-1)
+	emit(fmt.Sprintf("  bleu a0, a1, %s", skip))
+	emit(fmt.Sprintf("  j %s", otherwise))
+	emit(fmt.Sprintf("%s:", skip))
+	emit(fmt.Sprintf("  la a1, %s", addresses))
+	emit("  slli a0, a0, 2")
+	emit("  add a1, a1, a0")
+	emit("  lw a1, 0(a1)")
+	emit("  jr a1")
+	emit("  .balign 4")
+	emit(fmt.Sprintf("%s:", addresses))
+	labels := []string{}
+	for _, label := range table {
+		labels = append(labels, label.String())
+	}
+	emit(fmt.Sprintf("  .word %s", strings.Join(labels, ", ")))
+}
+
+// runtime is the ARM runtime in RV32IM, with the same 8-byte block
+// headers and bitmap.
+func (riscv32) runtime() string {
+	return riscv32Runtime
+}
+
+const riscv32Runtime = `
+.set BASELINE_HEAP_SIZE, %d
+
+.bss
+.balign 8
+__baseline_heap:
+  .space BASELINE_HEAP_SIZE
+__baseline_starts:
+  .space BASELINE_HEAP_SIZE / 64
+.data
+.balign 4
+__baseline_free:
+  .word 0
+__baseline_heap_ready:
+  .word 0
+__baseline_stack_base:
+  .word 0
+.text
+
+# __baseline_alloc returns a0 bytes of zeroed memory, collecting garbage
+# when the free list has no block large enough. The runtime leaves s0
+# alone, so the collector sees its frames as part of the caller's.
+.global __baseline_alloc
+__baseline_alloc:
+  addi sp, sp, -16
+  sw ra, 12(sp)
+  sw s1, 8(sp)
+  addi s1, a0, 15
+  andi s1, s1, -8
+  la a1, __baseline_heap_ready
+  lw a2, 0(a1)
+  bnez a2, .Lalloc_find
+  li a2, 1
+  sw a2, 0(a1)
+  la a0, __baseline_heap
+  li a2, BASELINE_HEAP_SIZE
+  sw a2, 0(a0)
+  sw zero, 4(a0)
+  la a1, __baseline_free
+  sw a0, 0(a1)
+.Lalloc_find:
+  jal .Lfind
+  bnez a0, .Lalloc_found
+  call __baseline_collect
+  jal .Lfind
+  bnez a0, .Lalloc_found
+  call abort
+.Lalloc_found:
+  mv t4, a0
+  jal .Lstart_bit
+  lbu a3, 0(a1)
+  or a3, a3, a2
+  sb a3, 0(a1)
+  lw a2, 0(t4)
+  add a2, t4, a2
+  addi a1, t4, 8
+.Lalloc_zero:
+  bgeu a1, a2, .Lalloc_done
+  sw zero, 0(a1)
+  addi a1, a1, 4
+  j .Lalloc_zero
+.Lalloc_done:
+  addi a0, t4, 8
+  lw s1, 8(sp)
+  lw ra, 12(sp)
+  addi sp, sp, 16
+  ret
+
+# __baseline_field returns the address of the field with id a1 of the
+# record at a0, aborting when the record's shape has no such field.
+.global __baseline_field
+__baseline_field:
+  lw a2, 0(a0)
+  addi a0, a0, 4
+  lw a3, 0(a2)
+  addi a2, a2, 4
+.Lfield_loop:
+  beqz a3, .Lfield_missing
+  addi a3, a3, -1
+  lw a4, 0(a2)
+  addi a2, a2, 4
+  beq a4, a1, .Lfield_found
+  addi a0, a0, 4
+  j .Lfield_loop
+.Lfield_found:
+  ret
+.Lfield_missing:
+  call abort
+
+# .Lfind takes the first free block of at least s1 bytes off the free list
+# and returns it in a0, or 0 when there is none. The rest of a larger
+# block stays on the list.
+.Lfind:
+  la a1, __baseline_free
+.Lfind_loop:
+  lw a0, 0(a1)
+  beqz a0, .Lfind_done
+  lw a2, 0(a0)
+  bgeu a2, s1, .Lfind_fits
+  addi a1, a0, 4
+  j .Lfind_loop
+.Lfind_fits:
+  sub a3, a2, s1
+  li a4, 16
+  bgeu a3, a4, .Lfind_split
+  lw a5, 4(a0)
+  sw a5, 0(a1)
+  ret
+.Lfind_split:
+  add a5, a0, s1
+  sw a3, 0(a5)
+  lw a6, 4(a0)
+  sw a6, 4(a5)
+  sw a5, 0(a1)
+  sw s1, 0(a0)
+.Lfind_done:
+  ret
+
+# .Lstart_bit returns the bitmap byte for the block at a0 in a1 and the
+# bit within it in a2.
+.Lstart_bit:
+  la a1, __baseline_heap
+  sub a3, a0, a1
+  srli a3, a3, 3
+  andi a2, a3, 7
+  li a1, 1
+  sll a2, a1, a2
+  la a1, __baseline_starts
+  srli a3, a3, 3
+  add a1, a1, a3
+  ret
+
+.global __baseline_collect
+__baseline_collect:
+  addi sp, sp, -16
+  sw ra, 12(sp)
+  sw s1, 8(sp)
+  sw s2, 4(sp)
+  sw s3, 0(sp)
+  la a0, __baseline_data_start
+  la a1, __baseline_data_end
+  jal .Lmark_range
+  la a0, __baseline_bss_start
+  la a1, __baseline_bss_end
+  jal .Lmark_range
+  la s1, __baseline_stack_base
+  lw s1, 0(s1)
+  mv s2, sp
+  mv s3, s0
+.Lcollect_frames:
+  beqz s3, .Lcollect_sweep
+  mv a0, s2
+  mv a1, s3
+  jal .Lmark_range
+  beq s3, s1, .Lcollect_sweep
+  addi s2, s3, 16
+  lw s3, 0(s3)
+  j .Lcollect_frames
+.Lcollect_sweep:
+  jal .Lsweep
+  lw s3, 0(sp)
+  lw s2, 4(sp)
+  lw s1, 8(sp)
+  lw ra, 12(sp)
+  addi sp, sp, 16
+  ret
+
+# .Lmark_range marks every block referenced by a word in [a0, a1).
+.Lmark_range:
+  addi sp, sp, -16
+  sw ra, 12(sp)
+  sw s1, 8(sp)
+  sw s2, 4(sp)
+  mv s1, a0
+  mv s2, a1
+.Lmark_range_loop:
+  bgeu s1, s2, .Lmark_range_done
+  lw a0, 0(s1)
+  addi s1, s1, 4
+  jal .Lmark
+  j .Lmark_range_loop
+.Lmark_range_done:
+  lw s2, 4(sp)
+  lw s1, 8(sp)
+  lw ra, 12(sp)
+  addi sp, sp, 16
+  ret
+
+# .Lmark marks the block whose payload a0 points to, if any, and then
+# everything reachable from it.
+.Lmark:
+  la a1, __baseline_heap
+  sub a2, a0, a1
+  li a3, 8
+  bltu a2, a3, .Lmark_skip
+  li a3, BASELINE_HEAP_SIZE
+  bgeu a2, a3, .Lmark_skip
+  andi a3, a2, 7
+  bnez a3, .Lmark_skip
+  addi sp, sp, -16
+  sw ra, 12(sp)
+  sw s1, 8(sp)
+  addi s1, a0, -8
+  mv a0, s1
+  jal .Lstart_bit
+  lbu a3, 0(a1)
+  and a3, a3, a2
+  beqz a3, .Lmark_done
+  lw a1, 0(s1)
+  andi a3, a1, 1
+  bnez a3, .Lmark_done
+  ori a2, a1, 1
+  sw a2, 0(s1)
+  addi a0, s1, 8
+  add a1, s1, a1
+  jal .Lmark_range
+.Lmark_done:
+  lw s1, 8(sp)
+  lw ra, 12(sp)
+  addi sp, sp, 16
+.Lmark_skip:
+  ret
+
+# .Lsweep frees unmarked blocks, clears the marks of the others and
+# rebuilds the free list in address order, merging neighbouring blocks.
+.Lsweep:
+  addi sp, sp, -32
+  sw ra, 28(sp)
+  sw s1, 24(sp)
+  sw s2, 20(sp)
+  sw s3, 16(sp)
+  sw s4, 12(sp)
+  sw s5, 8(sp)
+  sw s6, 4(sp)
+  la s1, __baseline_heap
+  li s2, BASELINE_HEAP_SIZE
+  add s2, s1, s2
+  la s5, __baseline_free
+  li s4, 0
+.Lsweep_loop:
+  bgeu s1, s2, .Lsweep_done
+  lw s3, 0(s1)
+  andi s6, s3, -2
+  mv a0, s1
+  jal .Lstart_bit
+  lbu a3, 0(a1)
+  and a4, a3, a2
+  beqz a4, .Lsweep_free
+  andi a4, s3, 1
+  bnez a4, .Lsweep_live
+  not a2, a2
+  and a3, a3, a2
+  sb a3, 0(a1)
+  j .Lsweep_free
+.Lsweep_live:
+  sw s6, 0(s1)
+  li s4, 0
+  add s1, s1, s6
+  j .Lsweep_loop
+.Lsweep_free:
+  beqz s4, .Lsweep_new_free
+  lw a0, 0(s4)
+  add a0, a0, s6
+  sw a0, 0(s4)
+  add s1, s1, s6
+  j .Lsweep_loop
+.Lsweep_new_free:
+  sw s6, 0(s1)
+  sw s1, 0(s5)
+  addi s5, s1, 4
+  mv s4, s1
+  add s1, s1, s6
+  j .Lsweep_loop
+.Lsweep_done:
+  sw zero, 0(s5)
+  lw s6, 4(sp)
+  lw s5, 8(sp)
+  lw s4, 12(sp)
+  lw s3, 16(sp)
+  lw s2, 20(sp)
+  lw s1, 24(sp)
+  lw ra, 28(sp)
+  addi sp, sp, 32
+  ret`
@@ -0,0 +1,260 @@
+package main
+
+import "fmt"
+
+// heapSize is the number of bytes the runtime can allocate.
+const heapSize = 1 << 20
+
+// emitRuntime emits the allocator, a conservative mark-sweep collector and
+// the field lookup for records.
+//
+// Every block in the heap starts with an 8-byte header: its size, with the
+// mark in bit 0, and the next free block while it is on the free list. A
+// bitmap with one bit per 8 bytes records where allocated blocks start, so
+// any word pointing at the payload of one of them counts as a reference.
+// Roots are the globals and the stack frames linked through fp, from the
+// collector up to the frame of main.
+func emitRuntime() {
+	emit(fmt.Sprintf(runtime, heapSize))
+}
+
+const runtime = `
+.set BASELINE_HEAP_SIZE, %d
+
+.bss
+.balign 8
+__baseline_heap:
+  .space BASELINE_HEAP_SIZE
+__baseline_starts:
+  .space BASELINE_HEAP_SIZE / 64
+.data
+.balign 4
+__baseline_free:
+  .word 0
+__baseline_heap_ready:
+  .word 0
+__baseline_stack_base:
+  .word 0
+.text
+
+@ __baseline_alloc returns r0 bytes of zeroed memory, collecting garbage
+@ when the free list has no block large enough.
+.global __baseline_alloc
+__baseline_alloc:
+  push {r4, r5, r6, lr}
+  add r4, r0, #15
+  bic r4, r4, #7
+  ldr r1, =__baseline_heap_ready
+  ldr r2, [r1]
+  cmp r2, #0
+  bne .Lalloc_find
+  mov r2, #1
+  str r2, [r1]
+  ldr r0, =__baseline_heap
+  ldr r2, =BASELINE_HEAP_SIZE
+  str r2, [r0]
+  mov r2, #0
+  str r2, [r0, #4]
+  ldr r1, =__baseline_free
+  str r0, [r1]
+.Lalloc_find:
+  bl .Lfind
+  cmp r0, #0
+  bne .Lalloc_found
+  bl __baseline_collect
+  bl .Lfind
+  cmp r0, #0
+  bne .Lalloc_found
+  bl abort
+.Lalloc_found:
+  mov r4, r0
+  bl .Lstart_bit
+  ldrb r3, [r1]
+  orr r3, r3, r2
+  strb r3, [r1]
+  ldr r2, [r4]
+  add r2, r4, r2
+  add r1, r4, #8
+  mov r3, #0
+.Lalloc_zero:
+  cmp r1, r2
+  strlo r3, [r1], #4
+  blo .Lalloc_zero
+  add r0, r4, #8
+  pop {r4, r5, r6, pc}
+
+@ __baseline_field returns the address of the field with id r1 of the
+@ record at r0, aborting when the record's shape has no such field.
+.global __baseline_field
+__baseline_field:
+  ldr r2, [r0], #4
+  ldr r3, [r2], #4
+.Lfield_loop:
+  subs r3, r3, #1
+  blo abort
+  ldr ip, [r2], #4
+  cmp ip, r1
+  bxeq lr
+  add r0, r0, #4
+  b .Lfield_loop
+
+@ .Lfind takes the first free block of at least r4 bytes off the free list
+@ and returns it in r0, or 0 when there is none. The rest of a larger
+@ block stays on the list.
+.Lfind:
+  ldr r1, =__baseline_free
+.Lfind_loop:
+  ldr r0, [r1]
+  cmp r0, #0
+  bxeq lr
+  ldr r2, [r0]
+  cmp r2, r4
+  addlo r1, r0, #4
+  blo .Lfind_loop
+  sub r3, r2, r4
+  cmp r3, #16
+  ldrlo r5, [r0, #4]
+  strlo r5, [r1]
+  bxlo lr
+  add r5, r0, r4
+  str r3, [r5]
+  ldr r6, [r0, #4]
+  str r6, [r5, #4]
+  str r5, [r1]
+  str r4, [r0]
+  bx lr
+
+@ .Lstart_bit returns the bitmap byte for the block at r0 in r1 and the
+@ bit within it in r2.
+.Lstart_bit:
+  ldr r1, =__baseline_heap
+  sub r3, r0, r1
+  lsr r3, r3, #3
+  and r2, r3, #7
+  mov r1, #1
+  lsl r2, r1, r2
+  ldr r1, =__baseline_starts
+  add r1, r1, r3, lsr #3
+  bx lr
+
+.global __baseline_collect
+__baseline_collect:
+  push {r4, r5, r6, r7, r8, r9, r10, r11, ip, lr}
+  ldr r0, =__baseline_data_start
+  ldr r1, =__baseline_data_end
+  bl .Lmark_range
+  ldr r0, =__baseline_bss_start
+  ldr r1, =__baseline_bss_end
+  bl .Lmark_range
+  ldr r7, =__baseline_stack_base
+  ldr r7, [r7]
+  mov r8, sp
+  mov r9, fp
+.Lcollect_frames:
+  cmp r9, #0
+  beq .Lcollect_sweep
+  mov r0, r8
+  mov r1, r9
+  bl .Lmark_range
+  cmp r9, r7
+  beq .Lcollect_sweep
+  add r8, r9, #8
+  ldr r9, [r9]
+  b .Lcollect_frames
+.Lcollect_sweep:
+  bl .Lsweep
+  pop {r4, r5, r6, r7, r8, r9, r10, r11, ip, pc}
+
+@ .Lmark_range marks every block referenced by a word in [r0, r1).
+.Lmark_range:
+  push {r4, r5, r6, lr}
+  mov r4, r0
+  mov r5, r1
+.Lmark_range_loop:
+  cmp r4, r5
+  bhs .Lmark_range_done
+  ldr r0, [r4], #4
+  bl .Lmark
+  b .Lmark_range_loop
+.Lmark_range_done:
+  pop {r4, r5, r6, pc}
+
+@ .Lmark marks the block whose payload r0 points to, if any, and then
+@ everything reachable from it.
+.Lmark:
+  ldr r1, =__baseline_heap
+  sub r2, r0, r1
+  cmp r2, #8
+  bxlo lr
+  ldr r3, =BASELINE_HEAP_SIZE
+  cmp r2, r3
+  bxhs lr
+  tst r2, #7
+  bxne lr
+  push {r4, lr}
+  sub r4, r0, #8
+  mov r0, r4
+  bl .Lstart_bit
+  ldrb r3, [r1]
+  tst r3, r2
+  beq .Lmark_done
+  ldr r1, [r4]
+  tst r1, #1
+  bne .Lmark_done
+  orr r2, r1, #1
+  str r2, [r4]
+  add r0, r4, #8
+  add r1, r4, r1
+  bl .Lmark_range
+.Lmark_done:
+  pop {r4, pc}
+
+@ .Lsweep frees unmarked blocks, clears the marks of the others and
+@ rebuilds the free list in address order, merging neighbouring blocks.
+.Lsweep:
+  push {r4, r5, r6, r7, r8, r9, r10, lr}
+  ldr r4, =__baseline_heap
+  ldr r5, =BASELINE_HEAP_SIZE
+  add r5, r4, r5
+  ldr r8, =__baseline_free
+  mov r7, #0
+.Lsweep_loop:
+  cmp r4, r5
+  bhs .Lsweep_done
+  ldr r6, [r4]
+  bic r9, r6, #1
+  mov r0, r4
+  bl .Lstart_bit
+  ldrb r3, [r1]
+  tst r3, r2
+  beq .Lsweep_free
+  tst r6, #1
+  bne .Lsweep_live
+  bic r3, r3, r2
+  strb r3, [r1]
+  b .Lsweep_free
+.Lsweep_live:
+  str r9, [r4]
+  mov r7, #0
+  add r4, r4, r9
+  b .Lsweep_loop
+.Lsweep_free:
+  cmp r7, #0
+  beq .Lsweep_new_free
+  ldr r0, [r7]
+  add r0, r0, r9
+  str r0, [r7]
+  add r4, r4, r9
+  b .Lsweep_loop
+.Lsweep_new_free:
+  str r9, [r4]
+  str r4, [r8]
+  add r8, r4, #4
+  mov r7, r4
+  add r4, r4, r9
+  b .Lsweep_loop
+.Lsweep_done:
+  mov r0, #0
+  str r0, [r8]
+  pop {r4, r5, r6, r7, r8, r9, r10, pc}
+  .ltorg`
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// dominance holds the dominator tree of a function's blocks. order lists
+// the blocks in reverse postorder, where every block comes after its
+// immediate dominator.
+type dominance struct {
+	order    []*irBlock
+	index    map[*irBlock]int
+	idom     map[*irBlock]*irBlock
+	children map[*irBlock][]*irBlock
+	frontier map[*irBlock][]*irBlock
+}
+
+// dominance computes the dominators with the iterative algorithm of
+// Cooper, Harvey and Kennedy, and from them the dominance frontiers.
+func (f *irFunction) dominance() *dominance {
+	d := &dominance{
+		index:    make(map[*irBlock]int),
+		idom:     make(map[*irBlock]*irBlock),
+		children: make(map[*irBlock][]*irBlock),
+		frontier: make(map[*irBlock][]*irBlock),
+	}
+	visited := make(map[*irBlock]bool)
+	var visit func(block *irBlock)
+	visit = func(block *irBlock) {
+		visited[block] = true
+		for _, successor := range block.successors() {
+			if !visited[successor] {
+				visit(successor)
+			}
+		}
+		d.order = append(d.order, block)
+	}
+	entry := f.blocks[0]
+	visit(entry)
+	slices.Reverse(d.order)
+	for i, block := range d.order {
+		d.index[block] = i
+	}
+
+	intersect := func(a, b *irBlock) *irBlock {
+		for a != b {
+			for d.index[a] > d.index[b] {
+				a = d.idom[a]
+			}
+			for d.index[b] > d.index[a] {
+				b = d.idom[b]
+			}
+		}
+		return a
+	}
+	d.idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, block := range d.order[1:] {
+			var idom *irBlock
+			for _, predecessor := range block.predecessors {
+				if d.idom[predecessor] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = predecessor
+				} else {
+					idom = intersect(predecessor, idom)
+				}
+			}
+			if d.idom[block] != idom {
+				d.idom[block] = idom
+				changed = true
+			}
+		}
+	}
+
+	for _, block := range d.order[1:] {
+		d.children[d.idom[block]] = append(d.children[d.idom[block]], block)
+	}
+	for _, block := range d.order {
+		if len(block.predecessors) < 2 {
+			continue
+		}
+		for _, predecessor := range block.predecessors {
+			for runner := predecessor; runner != d.idom[block]; runner = d.idom[runner] {
+				if !slices.Contains(d.frontier[runner], block) {
+					d.frontier[runner] = append(d.frontier[runner], block)
+				}
+			}
+		}
+	}
+	return d
+}
+
+// dominates reports whether every path from the entry to b goes through a.
+func (d *dominance) dominates(a, b *irBlock) bool {
+	for {
+		if a == b {
+			return true
+		}
+		if d.idom[b] == b {
+			return false
+		}
+		b = d.idom[b]
+	}
+}
+
+// phis returns how many instructions at the start of the block are phis.
+func (b *irBlock) phis() int {
+	for i, in := range b.instructions {
+		if in.op != irPhi {
+			return i
+		}
+	}
+	return len(b.instructions)
+}
+
+// toSSA puts the function in static single assignment form: every
+// virtual register is defined once, and registers that merge values
+// from different paths become phis. Phis are only placed for registers
+// used in some block before that block defines them, which are the
+// variables of the program; a variable read on a path that never
+// assigned it reads 0.
+func (f *irFunction) toSSA() {
+	d := f.dominance()
+
+	variables := make(map[irValue]bool)
+	definitions := make(map[irValue][]*irBlock)
+	for _, block := range f.blocks {
+		defined := make(map[irValue]bool)
+		for _, in := range block.instructions {
+			for _, arg := range in.args {
+				if !defined[arg] {
+					variables[arg] = true
+				}
+			}
+			if in.dst != noValue {
+				defined[in.dst] = true
+				if !slices.Contains(definitions[in.dst], block) {
+					definitions[in.dst] = append(definitions[in.dst], block)
+				}
+			}
+		}
+	}
+
+	// phis records the variable each inserted phi merges
+	phis := make(map[*irInstruction]irValue)
+	for _, variable := range slices.Sorted(maps.Keys(variables)) {
+		placed := make(map[*irBlock]bool)
+		work := slices.Clone(definitions[variable])
+		for len(work) > 0 {
+			block := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, frontier := range d.frontier[block] {
+				if placed[frontier] {
+					continue
+				}
+				placed[frontier] = true
+				phi := &irInstruction{op: irPhi, dst: variable, args: make([]irValue, len(frontier.predecessors))}
+				phis[phi] = variable
+				frontier.instructions = slices.Insert(frontier.instructions, 0, phi)
+				work = append(work, frontier)
+			}
+		}
+	}
+
+	undefined := noValue
+	stacks := make(map[irValue][]irValue)
+	current := func(variable irValue) irValue {
+		if stack := stacks[variable]; len(stack) > 0 {
+			return stack[len(stack)-1]
+		}
+		if undefined == noValue {
+			undefined = f.value()
+		}
+		return undefined
+	}
+
+	var rename func(block *irBlock)
+	rename = func(block *irBlock) {
+		pushed := []irValue{}
+		for _, in := range block.instructions {
+			if in.op != irPhi {
+				for i, arg := range in.args {
+					in.args[i] = current(arg)
+				}
+			}
+			if in.dst != noValue {
+				variable := in.dst
+				in.dst = f.value()
+				stacks[variable] = append(stacks[variable], in.dst)
+				pushed = append(pushed, variable)
+			}
+		}
+		for _, successor := range block.successors() {
+			index := slices.Index(successor.predecessors, block)
+			for _, in := range successor.instructions[:successor.phis()] {
+				in.args[index] = current(phis[in])
+			}
+		}
+		for _, child := range d.children[block] {
+			rename(child)
+		}
+		for _, variable := range pushed {
+			stacks[variable] = stacks[variable][:len(stacks[variable])-1]
+		}
+	}
+	rename(f.blocks[0])
+
+	if undefined != noValue {
+		entry := f.blocks[0]
+		at := slices.IndexFunc(entry.instructions, func(in *irInstruction) bool { return in.op != irParameter })
+		entry.instructions = slices.Insert(entry.instructions, at, &irInstruction{op: irConstant, dst: undefined})
+	}
+}
+
+// eliminatePhis takes the function out of SSA form for a backend. Each
+// phi gets a register of its own, which every predecessor sets just
+// before it leaves, and the phi becomes a copy of it. As only the phi's
+// block reads the new register, the copies are safe on critical edges,
+// and phis that read each other see the values from before the edge.
+func (f *irFunction) eliminatePhis() {
+	for _, block := range f.blocks {
+		count := block.phis()
+		for _, phi := range block.instructions[:count] {
+			incoming := f.value()
+			for i, predecessor := range block.predecessors {
+				at := len(predecessor.instructions) - 1
+				move := &irInstruction{op: irCopy, dst: incoming, args: []irValue{phi.args[i]}}
+				predecessor.instructions = slices.Insert(predecessor.instructions, at, move)
+			}
+			phi.op = irCopy
+			phi.args = []irValue{incoming}
+		}
+	}
+}
+
+// verify checks that the function is well formed, and in SSA form that
+// every register is defined once, before each of its uses.
+func (f *irFunction) verify(ssa bool) {
+	fail := func(format string, args ...any) {
+		panic(fmt.Sprintf("invalid IR in %s: %s", f.name, fmt.Sprintf(format, args...)))
+	}
+	d := f.dominance()
+	type site struct {
+		block *irBlock
+		index int
+	}
+	definitions := make(map[irValue]site)
+	for _, block := range f.blocks {
+		if block.terminator() == nil {
+			fail("%s does not end in a terminator", block.label)
+		}
+		if d.idom[block] == nil {
+			fail("%s is unreachable", block.label)
+		}
+		for i, in := range block.instructions {
+			if in.op.isTerminator() && i != len(block.instructions)-1 {
+				fail("%s has a terminator in the middle", block.label)
+			}
+			if in.op == irPhi && (i >= block.phis() || len(in.args) != len(block.predecessors)) {
+				fail("misplaced phi %s in %s", in, block.label)
+			}
+			if in.dst == noValue {
+				continue
+			}
+			if _, exists := definitions[in.dst]; exists && ssa {
+				fail("%s is defined twice", in.dst)
+			}
+			definitions[in.dst] = site{block, i}
+		}
+	}
+	if !ssa {
+		return
+	}
+	for _, block := range f.blocks {
+		for i, in := range block.instructions {
+			for j, arg := range in.args {
+				definition, exists := definitions[arg]
+				user := site{block, i}
+				if in.op == irPhi {
+					// the value flows in at the end of the predecessor
+					user = site{block.predecessors[j], len(block.predecessors[j].instructions)}
+				}
+				if !exists || definition.block == user.block && definition.index >= user.index ||
+					!d.dominates(definition.block, user.block) {
+					fail("%s in %s does not dominate its use in %s", arg, in, block.label)
+				}
+			}
+		}
+	}
+}
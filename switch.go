@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// switchCase is one clause of a switch. The default clause has no value.
+type switchCase struct {
+	value      int
+	isDefault  bool
+	statements []AST
+}
+
+// Switch jumps to the clause whose value equals its operand, or to the
+// default clause, and falls through the clauses after it until a break.
+// The clauses share one scope.
+type Switch struct {
+	value AST
+	cases []switchCase
+	pos   Position
+}
+
+// isDense reports whether a jump table over the case values would be at
+// least half full. A few cases are cheaper to compare one by one.
+func isDense(values []int) bool {
+	if len(values) < 4 {
+		return false
+	}
+	low, high := slices.Min(values), slices.Max(values)
+	return int64(high)-int64(low) < 2*int64(len(values))
+}
+
+// switchPlan holds the labels of a switch: one per clause, the end, and
+// the target of values no case matches, which is the default clause or
+// the end. values are the case values in order, and caseLabels their
+// clauses' labels.
+type switchPlan struct {
+	labels, caseLabels []*Label
+	values             []int
+	end, target        *Label
+}
+
+// plan labels the clauses, rejecting duplicate values and defaults.
+func (s Switch) plan() switchPlan {
+	plan := switchPlan{end: NewLabel()}
+	plan.target = plan.end
+	for _, clause := range s.cases {
+		label := NewLabel()
+		plan.labels = append(plan.labels, label)
+		if clause.isDefault {
+			if plan.target != plan.end {
+				panic("Multiple default clauses in switch")
+			}
+			plan.target = label
+			continue
+		}
+		if slices.Contains(plan.values, clause.value) {
+			panic(fmt.Sprintf("Duplicate case value: %d", clause.value))
+		}
+		plan.values = append(plan.values, clause.value)
+		plan.caseLabels = append(plan.caseLabels, label)
+	}
+	return plan
+}
+
+// table returns the lowest case value and the clause label for every value
+// from it to the highest, with target in the gaps.
+func (plan switchPlan) table() (int, []*Label) {
+	low, high := slices.Min(plan.values), slices.Max(plan.values)
+	table := make([]*Label, high-low+1)
+	for i := range table {
+		table[i] = plan.target
+	}
+	for i, value := range plan.values {
+		table[value-low] = plan.caseLabels[i]
+	}
+	return low, table
+}
+
+// scope returns the scope of the clauses, where break leaves the switch
+// while continue still refers to the enclosing loop.
+func (plan switchPlan) scope(env *Environment) *Environment {
+	scope := env.scope()
+	outer, _ := env.innermostLoop()
+	scope.loops = append(slices.Clone(env.loops), loopLabels{breakLabel: plan.end, continueLabel: outer.continueLabel})
+	return scope
+}
+
+func (s Switch) Equals(other AST) bool {
+	if otherSwitch, ok := other.(*Switch); ok {
+		if !s.value.Equals(otherSwitch.value) || len(s.cases) != len(otherSwitch.cases) {
+			return false
+		}
+		for i, clause := range s.cases {
+			otherClause := otherSwitch.cases[i]
+			if clause.isDefault != otherClause.isDefault || clause.value != otherClause.value ||
+				len(clause.statements) != len(otherClause.statements) {
+				return false
+			}
+			for j, statement := range clause.statements {
+				if !statement.Equals(otherClause.statements[j]) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// statements returns the statements of all clauses in order.
+func (s Switch) statements() []AST {
+	statements := []AST{}
+	for _, clause := range s.cases {
+		statements = append(statements, clause.statements...)
+	}
+	return statements
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+// vmNative is an extern provided by the virtual machine.
+type vmNative struct {
+	parameters int
+	call       func(m *vm, args []int32) int32
+}
+
+var vmNatives = map[string]vmNative{
+	"putchar": {parameters: 1, call: func(m *vm, args []int32) int32 {
+		m.output.WriteByte(byte(args[0]))
+		return args[0]
+	}},
+}
+
+// vm runs a bytecodeModule. Calls do not recurse in Go: the frames of the
+// callers are kept in a slice, their locals in one shared slice, and the
+// operand stack is shared by all of them. Like the C and LLVM backends,
+// it never reclaims the heap.
+type vm struct {
+	module  *bytecodeModule
+	natives []vmNative
+	heap    []int32
+	globals []int32
+	output  *bufio.Writer
+}
+
+type vmFrame struct {
+	function *bytecodeFunction
+	pc, base int
+}
+
+// newVM prepares to run module, writing what it prints to output.
+func newVM(module *bytecodeModule, output io.Writer) (*vm, error) {
+	m := &vm{
+		module:  module,
+		heap:    slices.Clone(module.heap),
+		globals: slices.Clone(module.globals),
+		output:  bufio.NewWriter(output),
+	}
+	for _, extern := range module.externs {
+		native, exists := vmNatives[extern.name]
+		if !exists {
+			return nil, fmt.Errorf("extern %s is not available in the virtual machine", extern.name)
+		}
+		if native.parameters != extern.parameters {
+			return nil, fmt.Errorf("extern %s takes %d parameters, not %d", extern.name, native.parameters, extern.parameters)
+		}
+		m.natives = append(m.natives, native)
+	}
+	return m, nil
+}
+
+func (m *vm) alloc(words int32) int32 {
+	address := len(m.heap)
+	if address+int(words) > math.MaxInt32 {
+		panic("out of memory")
+	}
+	m.heap = slices.Grow(m.heap, int(words))[:address+int(words)]
+	clear(m.heap[address:])
+	return int32(address)
+}
+
+// run calls main and returns its result.
+func (m *vm) run() (result int32, err error) {
+	if m.module.main < 0 {
+		return 0, fmt.Errorf("the program has no main function")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runtime error: %v", r)
+		}
+		if flushErr := m.output.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	functions := m.module.functions
+	function := &functions[m.module.main]
+	code := function.code
+	frames := []vmFrame{}
+	locals := make([]int32, function.locals)
+	stack := make([]int32, 0, 256)
+	pc, base := 0, 0
+
+	// enter starts a call of callee with given arguments on the stack, of
+	// which it takes as many as it has parameters.
+	enter := func(callee *bytecodeFunction, given int) {
+		frames = append(frames, vmFrame{function: function, pc: pc, base: base})
+		function, code, pc, base = callee, callee.code, 0, len(locals)
+		locals = slices.Grow(locals, callee.locals)[:base+callee.locals]
+		clear(locals[base:])
+		copy(locals[base:], stack[len(stack)-given:len(stack)-given+min(given, callee.parameters)])
+		stack = stack[:len(stack)-given]
+	}
+
+	for {
+		in := code[pc]
+		pc++
+		top := len(stack) - 1
+		switch in.op {
+		case opConstant:
+			stack = append(stack, in.operand)
+		case opLoad:
+			stack = append(stack, locals[base+int(in.operand)])
+		case opStore:
+			locals[base+int(in.operand)] = stack[top]
+			stack = stack[:top]
+		case opLoadGlobal:
+			stack = append(stack, m.globals[in.operand])
+		case opStoreGlobal:
+			m.globals[in.operand] = stack[top]
+			stack = stack[:top]
+		case opLoadHeap:
+			stack[top] = m.heap[stack[top]+in.operand]
+		case opStoreHeap:
+			m.heap[stack[top-1]+in.operand] = stack[top]
+			stack = stack[:top-1]
+		case opAlloc:
+			stack = append(stack, m.alloc(in.operand))
+		case opBox:
+			cell := m.alloc(1)
+			m.heap[cell] = stack[top]
+			stack[top] = cell
+		case opField:
+			record := stack[top]
+			shape := m.heap[record]
+			found := false
+			for i := int32(1); i <= m.heap[shape]; i++ {
+				if m.heap[shape+i] == in.operand {
+					stack[top] = record + i
+					found = true
+					break
+				}
+			}
+			if !found {
+				panic(fmt.Sprintf("record has no field with id %d", in.operand))
+			}
+		case opClosure:
+			closure := m.alloc(2)
+			m.heap[closure] = in.operand
+			m.heap[closure+1] = stack[top]
+			stack[top] = closure
+		case opDuplicate:
+			stack = append(stack, stack[top])
+		case opPop:
+			stack = stack[:top]
+		case opAdd:
+			stack[top-1] += stack[top]
+			stack = stack[:top]
+		case opSubtract:
+			stack[top-1] -= stack[top]
+			stack = stack[:top]
+		case opMultiply:
+			stack[top-1] *= stack[top]
+			stack = stack[:top]
+		case opDivide:
+			// Go already wraps the most negative number divided by -1
+			if stack[top] == 0 {
+				stack[top-1] = 0
+			} else {
+				stack[top-1] /= stack[top]
+			}
+			stack = stack[:top]
+		case opRemainder:
+			if stack[top] != 0 {
+				stack[top-1] %= stack[top]
+			}
+			stack = stack[:top]
+		case opNegate:
+			stack[top] = -stack[top]
+		case opNot:
+			stack[top] = vmBoolean(stack[top] == 0)
+		case opEqual:
+			stack[top-1] = vmBoolean(stack[top-1] == stack[top])
+			stack = stack[:top]
+		case opNotEqual:
+			stack[top-1] = vmBoolean(stack[top-1] != stack[top])
+			stack = stack[:top]
+		case opJump:
+			pc = int(in.operand)
+		case opJumpIfZero:
+			if stack[top] == 0 {
+				pc = int(in.operand)
+			}
+			stack = stack[:top]
+		case opCall:
+			callee := &functions[in.operand]
+			enter(callee, callee.parameters)
+		case opCallExtern:
+			native := m.natives[in.operand]
+			args := stack[len(stack)-native.parameters:]
+			result := native.call(m, args)
+			stack = append(stack[:len(stack)-native.parameters], result)
+		case opCallClosure:
+			closure := stack[top]
+			given := int(in.operand) + 1
+			// the environment takes the closure's place after the arguments
+			stack[top] = m.heap[closure+1]
+			if index := m.heap[closure]; index >= 0 {
+				enter(&functions[index], given)
+			} else {
+				native := m.natives[-1-index]
+				args := stack[len(stack)-given:]
+				result := native.call(m, args[:native.parameters])
+				stack = append(stack[:len(stack)-given], result)
+			}
+		case opReturn:
+			result := stack[top]
+			stack = stack[:top]
+			locals = locals[:base]
+			if len(frames) == 0 {
+				return result, nil
+			}
+			caller := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			function, code, pc, base = caller.function, caller.function.code, caller.pc, caller.base
+			stack = append(stack, result)
+		}
+	}
+}
+
+func vmBoolean(value bool) int32 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// runBytecode runs module, printing to standard output, and exits with
+// the result of main.
+func runBytecode(module *bytecodeModule) {
+	m, err := newVM(module, os.Stdout)
+	if err == nil {
+		var result int32
+		result, err = m.run()
+		if err == nil {
+			os.Exit(int(result))
+		}
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// loadBytecode reads a .bbc file.
+func loadBytecode(path string) (*bytecodeModule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	module := &bytecodeModule{}
+	if err := module.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return module, nil
+}
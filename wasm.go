@@ -0,0 +1,749 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// wasmDataStart is where the data segment starts; address 0 stays unused
+// so that no object is null.
+const wasmDataStart = 16
+
+// wasmGenerator emits a WebAssembly text module. Every value is an i32
+// and every function returns one, 0 when it ends without a return.
+// Variables are wasm locals, except that captured variables hold a
+// pointer to a heap cell as on ARM, and top-level variables are globals.
+//
+// Closures, records and cells live in linear memory, laid out as on ARM
+// with a table index in place of the code address. A closure is called
+// with call_indirect; top-level functions used as values go through an
+// adapter that drops the environment. Memory is never reclaimed: the
+// locals and the operand stack are invisible to a collector, so the heap
+// only grows.
+type wasmGenerator struct {
+	functions [][]string
+	table     []string
+	arities   map[string]int
+	// callTypes are the parameter counts of closure calls
+	callTypes map[int]bool
+	imports   []string
+	data      []wasmData
+	dataEnd   int
+	closures  map[string]int
+	shapes    map[string]int
+}
+
+// wasmData is a run of words in the data segment.
+type wasmData struct {
+	address int
+	words   []int
+}
+
+// wasmFunction is a function being generated. locals names the
+// parameters and locals by index.
+type wasmFunction struct {
+	locals []string
+	code   []string
+	depth  int
+}
+
+func (f *wasmFunction) emit(format string, args ...any) {
+	f.code = append(f.code, strings.Repeat("  ", f.depth+2)+fmt.Sprintf(format, args...))
+}
+
+// open starts a block, loop or if; close ends it.
+func (f *wasmFunction) open(format string, args ...any) {
+	f.emit(format, args...)
+	f.depth++
+}
+
+func (f *wasmFunction) close() {
+	f.depth--
+	f.emit("end")
+}
+
+// local adds a local named after name, made unique, and returns its index.
+func (f *wasmFunction) local(name string) int {
+	unique := name
+	for i := 1; slices.Contains(f.locals, unique); i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	f.locals = append(f.locals, unique)
+	return len(f.locals) - 1
+}
+
+// temporary adds a local for an intermediate value.
+func (f *wasmFunction) temporary() string {
+	return f.name(f.local("#temporary"))
+}
+
+func (f *wasmFunction) name(index int) string {
+	return "$" + f.locals[index]
+}
+
+func wasmLabel(label *Label) string {
+	return fmt.Sprintf("$L%d", label.value)
+}
+
+// word allocates words in the data segment and returns their address.
+func (w *wasmGenerator) word(words ...int) int {
+	address := w.dataEnd
+	w.data = append(w.data, wasmData{address: address, words: words})
+	w.dataEnd += 4 * len(words)
+	return address
+}
+
+func (w *wasmGenerator) shape(names []string, env *Environment) int {
+	names = layout(names)
+	key := strings.Join(names, ",")
+	if address, exists := w.shapes[key]; exists {
+		return address
+	}
+	words := []int{len(names)}
+	for _, name := range names {
+		words = append(words, env.symbols.fieldId(name))
+	}
+	w.shapes[key] = w.word(words...)
+	return w.shapes[key]
+}
+
+// staticClosure returns the address of the closure of a top-level or
+// imported function, whose adapter ignores the environment.
+func (w *wasmGenerator) staticClosure(name string) int {
+	if address, exists := w.closures[name]; exists {
+		return address
+	}
+	arity := w.arities[name]
+	params := strings.Repeat(" i32", arity+1)
+	adapter := []string{fmt.Sprintf("  (func $%s#closure (param%s) (result i32)", name, params)}
+	for i := 0; i < arity; i++ {
+		adapter = append(adapter, fmt.Sprintf("    local.get %d", i))
+	}
+	adapter = append(adapter, fmt.Sprintf("    call $%s)", name))
+	w.functions = append(w.functions, adapter)
+	w.table = append(w.table, fmt.Sprintf("$%s#closure", name))
+	w.closures[name] = w.word(len(w.table)-1, 0)
+	return w.closures[name]
+}
+
+// emit generates the module of a program.
+func (w *wasmGenerator) emit(node AST, env *Environment) {
+	program, ok := node.(Program)
+	if !ok {
+		panic(fmt.Sprintf("cannot compile %T outside of a program", node))
+	}
+	w.emitProgram(program, env)
+}
+
+func (w *wasmGenerator) emitProgram(p Program, env *Environment) {
+	w.arities = make(map[string]int)
+	w.callTypes = make(map[int]bool)
+	w.closures = make(map[string]int)
+	w.shapes = make(map[string]int)
+	w.dataEnd = wasmDataStart
+
+	parts := p.split(env)
+	for _, statement := range p.statements {
+		switch statement := statement.(type) {
+		case Function:
+			w.arities[statement.name] = len(statement.parameters)
+		case Extern:
+			if _, exists := w.arities[statement.name]; !exists {
+				w.arities[statement.name] = len(statement.parameters)
+				w.imports = append(w.imports, fmt.Sprintf("  (import \"env\" \"%s\" (func $%s (param%s) (result i32)))",
+					statement.name, statement.name, strings.Repeat(" i32", len(statement.parameters))))
+			}
+		}
+	}
+
+	w.function(parts.init(), env, nil)
+	hasMain := false
+	for _, function := range parts.functions {
+		switch function := function.(type) {
+		case Function:
+			hasMain = hasMain || function.name == "main"
+			w.function(function, env, nil)
+		case Main:
+			hasMain = true
+			w.function(Function{name: "main", parameters: []string{}, body: Block{statements: function.statements}}, env, nil)
+		}
+	}
+	if _, declared := w.arities["putchar"]; !declared {
+		// assert prints through putchar
+		w.imports = append(w.imports, `  (import "env" "putchar" (func $putchar (param i32) (result i32)))`)
+	}
+
+	heapStart := (w.dataEnd + 7) &^ 7
+	emit("(module")
+	for _, arity := range slices.Sorted(func(yield func(int) bool) {
+		for arity := range w.callTypes {
+			if !yield(arity) {
+				return
+			}
+		}
+	}) {
+		emit(fmt.Sprintf("  (type $closure%d (func (param%s) (result i32)))", arity, strings.Repeat(" i32", arity)))
+	}
+	for _, line := range w.imports {
+		emit(line)
+	}
+	emit(fmt.Sprintf("  (memory (export \"memory\") %d)", heapStart/65536+1))
+	emit(fmt.Sprintf("  (table %d funcref)", len(w.table)))
+	emit(fmt.Sprintf("  (global $__baseline_heap_top (mut i32) (i32.const %d))", heapStart))
+	for _, global := range parts.initialized {
+		emit(fmt.Sprintf("  (global $%s (mut i32) (i32.const %d))", global.name, global.value.(Number).value))
+	}
+	for _, name := range parts.uninitialized {
+		emit(fmt.Sprintf("  (global $%s (mut i32) (i32.const 0))", name))
+	}
+	if len(w.table) > 0 {
+		emit(fmt.Sprintf("  (elem (i32.const 0) %s)", strings.Join(w.table, " ")))
+	}
+	for _, data := range w.data {
+		bytes := strings.Builder{}
+		for _, word := range data.words {
+			for shift := 0; shift < 32; shift += 8 {
+				fmt.Fprintf(&bytes, "\\%02x", uint32(word)>>shift&0xff)
+			}
+		}
+		emit(fmt.Sprintf("  (data (i32.const %d) \"%s\")", data.address, bytes.String()))
+	}
+	for _, function := range w.functions {
+		for _, line := range function {
+			emit(line)
+		}
+	}
+	emit(wasmRuntime)
+	if hasMain {
+		emit(`  (export "main" (func $main))`)
+	}
+	emit(")")
+}
+
+// function generates a function. captures numbers the variables in the
+// environment of a closure.
+func (w *wasmGenerator) function(function Function, outer *Environment, captures map[string]int) {
+	f := &wasmFunction{}
+	env := NewEnvironment()
+	env.symbols = outer.symbols
+	env.boxed = capturedVariables(function.body)
+	env.captures = captures
+	for _, param := range function.parameters {
+		env.locals[param] = f.local(param)
+	}
+	for _, param := range function.parameters {
+		if env.boxed[param] {
+			f.emit("local.get %s", f.name(env.locals[param]))
+			f.emit("call $__baseline_box")
+			f.emit("local.set %s", f.name(env.locals[param]))
+		}
+	}
+	if function.name == "main" {
+		f.emit("call $%s", initFunction)
+		f.emit("drop")
+	}
+	w.statement(function.body, env, f)
+	f.emit("i32.const 0")
+
+	lines := []string{fmt.Sprintf("  (func $%s", function.name)}
+	header := ""
+	for i := range function.parameters {
+		header += fmt.Sprintf(" (param %s i32)", f.name(i))
+	}
+	lines[0] += header + " (result i32)"
+	if len(f.locals) > len(function.parameters) {
+		locals := []string{}
+		for i := len(function.parameters); i < len(f.locals); i++ {
+			locals = append(locals, fmt.Sprintf("(local %s i32)", f.name(i)))
+		}
+		lines = append(lines, "    "+strings.Join(locals, " "))
+	}
+	lines = append(lines, f.code...)
+	lines[len(lines)-1] += ")"
+	w.functions = append(w.functions, lines)
+}
+
+func (w *wasmGenerator) statement(node AST, env *Environment, f *wasmFunction) {
+	switch node := node.(type) {
+	case Return:
+		w.expression(node.term, env, f)
+		f.emit("return")
+	case Block:
+		scope := env.scope()
+		for _, statement := range node.statements {
+			w.statement(statement, scope, f)
+		}
+	case If:
+		w.expression(node.conditional, env, f)
+		f.open("if")
+		w.statement(node.consequence, env.scope(), f)
+		if node.alternative != nil {
+			f.depth--
+			f.emit("else")
+			f.depth++
+			w.statement(node.alternative, env.scope(), f)
+		}
+		f.close()
+	case While:
+		loopStart := NewLabel()
+		loopEnd := NewLabel()
+		f.open("block %s", wasmLabel(loopEnd))
+		f.open("loop %s", wasmLabel(loopStart))
+		w.expression(node.conditional, env, f)
+		f.emit("i32.eqz")
+		f.emit("br_if %s", wasmLabel(loopEnd))
+		body := env.scope()
+		body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStart})
+		w.statement(node.body, body, f)
+		f.emit("br %s", wasmLabel(loopStart))
+		f.close()
+		f.close()
+	case For:
+		w.forStatement(node, env, f)
+	case Switch:
+		w.switchStatement(node, env, f)
+	case Break:
+		loop, ok := env.innermostLoop()
+		if !ok {
+			panic("break statement outside of a loop or switch")
+		}
+		f.emit("br %s", wasmLabel(loop.breakLabel))
+	case Continue:
+		loop, ok := env.innermostLoop()
+		if !ok || loop.continueLabel == nil {
+			panic("continue statement outside of a loop")
+		}
+		f.emit("br %s", wasmLabel(loop.continueLabel))
+	case Assign:
+		w.assign(node.name, node.value, env, f)
+	case MemberAssign:
+		w.expression(node.object, env, f)
+		if index, known := env.symbols.fields[node.pos]; known {
+			w.expression(node.value, env, f)
+			f.emit("i32.store offset=%d", fieldOffset(index))
+			return
+		}
+		f.emit("i32.const %d", env.symbols.fieldId(node.field))
+		f.emit("call $%s", fieldFunction)
+		w.expression(node.value, env, f)
+		f.emit("i32.store")
+	case Var:
+		if isRecursive(node) {
+			f.emit("i32.const 0")
+			w.declare(node, env, f)
+			w.assign(node.name, node.value, env, f)
+			return
+		}
+		w.expression(node.value, env, f)
+		w.declare(node, env, f)
+	case Assert:
+		w.expression(node.condition, env, f)
+		f.emit("i32.const 1")
+		f.emit("i32.eq")
+		f.open("if (result i32)")
+		f.emit("i32.const %d", '.')
+		f.depth--
+		f.emit("else")
+		f.depth++
+		f.emit("i32.const %d", 'F')
+		f.close()
+		f.emit("call $putchar")
+		f.emit("drop")
+	case Function, Extern, Import, Export:
+		panic(misplaced(node))
+	default:
+		w.expression(node, env, f)
+		f.emit("drop")
+	}
+}
+
+func (w *wasmGenerator) forStatement(node For, env *Environment, f *wasmFunction) {
+	loopStart := NewLabel()
+	loopStep := NewLabel()
+	loopEnd := NewLabel()
+
+	// a variable declared in init is visible to the whole loop only
+	scope := env.scope()
+	if node.init != nil {
+		w.statement(node.init, scope, f)
+	}
+	f.open("block %s", wasmLabel(loopEnd))
+	f.open("loop %s", wasmLabel(loopStart))
+	if node.conditional != nil {
+		w.expression(node.conditional, scope, f)
+		f.emit("i32.eqz")
+		f.emit("br_if %s", wasmLabel(loopEnd))
+	}
+	body := scope.scope()
+	body.loops = append(body.loops, loopLabels{breakLabel: loopEnd, continueLabel: loopStep})
+	f.open("block %s", wasmLabel(loopStep))
+	w.statement(node.body, body, f)
+	f.close()
+	if node.step != nil {
+		w.statement(node.step, scope, f)
+	}
+	f.emit("br %s", wasmLabel(loopStart))
+	f.close()
+	f.close()
+}
+
+// switchStatement nests a block per clause, the first innermost, so that
+// branching to a clause's block continues with the clause's statements
+// and falls through into the next clause.
+func (w *wasmGenerator) switchStatement(node Switch, env *Environment, f *wasmFunction) {
+	plan := node.plan()
+	f.open("block %s", wasmLabel(plan.end))
+	for i := len(plan.labels) - 1; i >= 0; i-- {
+		f.open("block %s", wasmLabel(plan.labels[i]))
+	}
+	if isDense(plan.values) {
+		low, table := plan.table()
+		w.expression(node.value, env, f)
+		f.emit("i32.const %d", low)
+		f.emit("i32.sub")
+		labels := []string{}
+		for _, label := range table {
+			labels = append(labels, wasmLabel(label))
+		}
+		f.emit("br_table %s %s", strings.Join(labels, " "), wasmLabel(plan.target))
+	} else {
+		value := f.temporary()
+		w.expression(node.value, env, f)
+		f.emit("local.set %s", value)
+		for i, caseValue := range plan.values {
+			f.emit("local.get %s", value)
+			f.emit("i32.const %d", caseValue)
+			f.emit("i32.eq")
+			f.emit("br_if %s", wasmLabel(plan.caseLabels[i]))
+		}
+		f.emit("br %s", wasmLabel(plan.target))
+	}
+
+	scope := plan.scope(env)
+	for _, clause := range node.cases {
+		f.close()
+		for _, statement := range clause.statements {
+			w.statement(statement, scope, f)
+		}
+	}
+	f.close()
+}
+
+// declare binds a variable to a new local and stores the value on the
+// stack into it.
+func (w *wasmGenerator) declare(v Var, env *Environment, f *wasmFunction) {
+	if _, exists := env.locals[v.name]; exists {
+		panic(fmt.Sprintf("Variable already declared in this scope: %s", v.name))
+	}
+	env.locals[v.name] = f.local(v.name)
+	if env.boxed[v.name] {
+		f.emit("call $__baseline_box")
+	}
+	f.emit("local.set %s", f.name(env.locals[v.name]))
+}
+
+// assign stores value into name. The address of a cell goes on the stack
+// before the value.
+func (w *wasmGenerator) assign(name string, value AST, env *Environment, f *wasmFunction) {
+	if index, exists := env.lookup(name); exists {
+		if !env.boxed[name] {
+			w.expression(value, env, f)
+			f.emit("local.set %s", f.name(index))
+			return
+		}
+		f.emit("local.get %s", f.name(index))
+	} else if _, exists := env.captures[name]; exists {
+		w.cell(name, env, f)
+	} else if env.symbols.globals[name] {
+		w.expression(value, env, f)
+		f.emit("global.set $%s", name)
+		return
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+	w.expression(value, env, f)
+	f.emit("i32.store")
+}
+
+// cell pushes the address of the heap cell of a boxed or captured
+// variable.
+func (w *wasmGenerator) cell(name string, env *Environment, f *wasmFunction) {
+	if index, exists := env.lookup(name); exists {
+		f.emit("local.get %s", f.name(index))
+		return
+	}
+	environment, _ := env.lookup(closureParameter)
+	f.emit("local.get %s", f.name(environment))
+	f.emit("i32.load offset=%d", 4*env.captures[name])
+}
+
+func (w *wasmGenerator) variable(name string, env *Environment, f *wasmFunction) {
+	if index, exists := env.lookup(name); exists {
+		f.emit("local.get %s", f.name(index))
+		if env.boxed[name] {
+			f.emit("i32.load")
+		}
+	} else if _, exists := env.captures[name]; exists {
+		w.cell(name, env, f)
+		f.emit("i32.load")
+	} else if env.symbols.globals[name] {
+		f.emit("global.get $%s", name)
+	} else if env.symbols.functions[name] {
+		f.emit("i32.const %d", w.staticClosure(name))
+	} else {
+		panic(fmt.Sprintf("Undefined variable: %s", name))
+	}
+}
+
+// wasmOperators are the instructions of the binary operators; division
+// goes through the runtime, which gives 0 rather than trapping when
+// dividing by zero, as on ARM.
+var wasmOperators = map[string]string{
+	"Equal":    "i32.eq",
+	"NotEqual": "i32.ne",
+	"Add":      "i32.add",
+	"Subtract": "i32.sub",
+	"Multiply": "i32.mul",
+	"Divide":   "call $__baseline_divide",
+	"Modulo":   "call $__baseline_remainder",
+}
+
+func (w *wasmGenerator) binary(left, right AST, operator string, env *Environment, f *wasmFunction) {
+	w.expression(left, env, f)
+	w.expression(right, env, f)
+	f.emit("%s", wasmOperators[operator])
+}
+
+func (w *wasmGenerator) expression(node AST, env *Environment, f *wasmFunction) {
+	switch node := node.(type) {
+	case Number:
+		f.emit("i32.const %d", int32(node.value))
+	case Boolean:
+		if node.value {
+			f.emit("i32.const 1")
+		} else {
+			f.emit("i32.const 0")
+		}
+	case Id:
+		w.variable(node.value, env, f)
+	case Not:
+		w.expression(node.term, env, f)
+		f.emit("i32.eqz")
+	case Negate:
+		f.emit("i32.const 0")
+		w.expression(node.term, env, f)
+		f.emit("i32.sub")
+	case Equal:
+		w.binary(node.left, node.right, "Equal", env, f)
+	case NotEqual:
+		w.binary(node.left, node.right, "NotEqual", env, f)
+	case Add:
+		w.binary(node.left, node.right, "Add", env, f)
+	case Subtract:
+		w.binary(node.left, node.right, "Subtract", env, f)
+	case Multiply:
+		w.binary(node.left, node.right, "Multiply", env, f)
+	case Divide:
+		w.binary(node.left, node.right, "Divide", env, f)
+	case Modulo:
+		w.binary(node.left, node.right, "Modulo", env, f)
+	case Call:
+		for _, arg := range node.args {
+			w.expression(arg, env, f)
+		}
+		if !env.isVariable(node.callee) {
+			f.emit("call $%s", node.callee)
+			return
+		}
+		// the closure's environment is passed after the declared arguments
+		w.variable(node.callee, env, f)
+		f.emit("i32.load offset=4")
+		w.variable(node.callee, env, f)
+		f.emit("i32.load")
+		w.callTypes[len(node.args)+1] = true
+		f.emit("call_indirect (type $closure%d)", len(node.args)+1)
+	case FunctionExpression:
+		w.closure(node, env, f)
+	case Record:
+		names := layout(node.names())
+		for i := 1; i < len(names); i++ {
+			if names[i] == names[i-1] {
+				panic(fmt.Sprintf("Duplicate field: %s", names[i]))
+			}
+		}
+		record := f.temporary()
+		f.emit("i32.const %d", fieldOffset(len(names)))
+		f.emit("call $%s", allocFunction)
+		f.emit("local.tee %s", record)
+		f.emit("i32.const %d", w.shape(names, env))
+		f.emit("i32.store")
+		for _, field := range node.fields {
+			f.emit("local.get %s", record)
+			w.expression(field.value, env, f)
+			f.emit("i32.store offset=%d", fieldOffset(slices.Index(names, field.name)))
+		}
+		f.emit("local.get %s", record)
+	case Member:
+		w.expression(node.object, env, f)
+		if index, known := env.symbols.fields[node.pos]; known {
+			f.emit("i32.load offset=%d", fieldOffset(index))
+			return
+		}
+		f.emit("i32.const %d", env.symbols.fieldId(node.field))
+		f.emit("call $%s", fieldFunction)
+		f.emit("i32.load")
+	default:
+		panic(fmt.Sprintf("%T cannot be used as a value", node))
+	}
+}
+
+// closure generates the code of a function expression as a function of
+// its own and allocates the closure: its table index followed by the
+// environment, an array of the cells of the captured variables.
+func (w *wasmGenerator) closure(fe FunctionExpression, env *Environment, f *wasmFunction) {
+	code := NewLabel()
+	captures := fe.captures(env)
+	indexes := make(map[string]int)
+	for i, name := range captures {
+		indexes[name] = i
+	}
+	w.table = append(w.table, "$"+code.String())
+	index := len(w.table) - 1
+	w.function(fe.function(code), env, indexes)
+
+	closure := f.temporary()
+	f.emit("i32.const 8")
+	f.emit("call $%s", allocFunction)
+	f.emit("local.tee %s", closure)
+	f.emit("i32.const %d", index)
+	f.emit("i32.store")
+	f.emit("local.get %s", closure)
+	if len(captures) == 0 {
+		f.emit("i32.const 0")
+	} else {
+		environment := f.temporary()
+		f.emit("i32.const %d", 4*len(captures))
+		f.emit("call $%s", allocFunction)
+		f.emit("local.set %s", environment)
+		for i, name := range captures {
+			f.emit("local.get %s", environment)
+			w.cell(name, env, f)
+			f.emit("i32.store offset=%d", 4*i)
+		}
+		f.emit("local.get %s", environment)
+	}
+	f.emit("i32.store offset=4")
+	f.emit("local.get %s", closure)
+}
+
+// wasmRuntime allocates from the end of the data segment, growing memory
+// as needed, and provides the field lookup for records and the division
+// helpers.
+const wasmRuntime = `  (func $__baseline_alloc (param $size i32) (result i32)
+    (local $block i32)
+    global.get $__baseline_heap_top
+    local.set $block
+    local.get $block
+    local.get $size
+    i32.const 7
+    i32.add
+    i32.const -8
+    i32.and
+    i32.add
+    global.set $__baseline_heap_top
+    global.get $__baseline_heap_top
+    memory.size
+    i32.const 16
+    i32.shl
+    i32.gt_u
+    if
+      global.get $__baseline_heap_top
+      memory.size
+      i32.const 16
+      i32.shl
+      i32.sub
+      i32.const 65535
+      i32.add
+      i32.const 16
+      i32.shr_u
+      memory.grow
+      i32.const -1
+      i32.eq
+      if
+        unreachable
+      end
+    end
+    local.get $block)
+  (func $__baseline_box (param $value i32) (result i32)
+    (local $cell i32)
+    i32.const 4
+    call $__baseline_alloc
+    local.tee $cell
+    local.get $value
+    i32.store
+    local.get $cell)
+  (func $__baseline_field (param $record i32) (param $id i32) (result i32)
+    (local $shape i32) (local $count i32)
+    local.get $record
+    i32.load
+    local.tee $shape
+    i32.load
+    local.set $count
+    block $missing
+      loop $search
+        local.get $count
+        i32.eqz
+        br_if $missing
+        local.get $record
+        i32.const 4
+        i32.add
+        local.set $record
+        local.get $shape
+        i32.const 4
+        i32.add
+        local.tee $shape
+        i32.load
+        local.get $id
+        i32.eq
+        if
+          local.get $record
+          return
+        end
+        local.get $count
+        i32.const 1
+        i32.sub
+        local.set $count
+        br $search
+      end
+    end
+    unreachable)
+  (func $__baseline_divide (param $dividend i32) (param $divisor i32) (result i32)
+    local.get $divisor
+    i32.eqz
+    if
+      i32.const 0
+      return
+    end
+    local.get $divisor
+    i32.const -1
+    i32.eq
+    if
+      i32.const 0
+      local.get $dividend
+      i32.sub
+      return
+    end
+    local.get $dividend
+    local.get $divisor
+    i32.div_s)
+  (func $__baseline_remainder (param $dividend i32) (param $divisor i32) (result i32)
+    local.get $divisor
+    i32.eqz
+    if
+      local.get $dividend
+      return
+    end
+    local.get $dividend
+    local.get $divisor
+    i32.rem_s)`